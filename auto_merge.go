@@ -0,0 +1,77 @@
+package bitcask_go
+
+import (
+	"context"
+	"time"
+)
+
+// startAutoMerge 根据配置启动后台自动 merge
+func (db *DB) startAutoMerge() {
+	if db.options.AutoMergeRatio <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	db.stopMerge = cancel
+	db.mergeDone = make(chan struct{})
+	go db.autoMerge(ctx)
+}
+
+// stopAutoMerge 停止后台自动 merge，正在进行的 merge 会被取消，等待后台 goroutine 退出之后返回
+func (db *DB) stopAutoMerge() {
+	if db.stopMerge == nil {
+		return
+	}
+	db.stopMerge()
+	<-db.mergeDone
+	db.stopMerge = nil
+}
+
+// autoMerge 定期检查可回收空间，达到阈值时执行 merge
+func (db *DB) autoMerge(ctx context.Context) {
+	defer close(db.mergeDone)
+
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if db.needAutoMerge(now) {
+				// merge 失败时数据不受影响，等待下一次检查
+				_ = db.MergeContext(ctx)
+			}
+		}
+	}
+}
+
+// needAutoMerge 判断当前是否需要执行自动 merge
+func (db *DB) needAutoMerge(now time.Time) bool {
+	if !db.options.AutoMergeWindow.contains(now) {
+		return false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.isMerging {
+		return false
+	}
+	reclaimable, total := db.reclaimableStat()
+	if total == 0 || reclaimable < db.options.AutoMergeMinBytes {
+		return false
+	}
+	return float32(reclaimable)/float32(total) >= db.options.AutoMergeRatio
+}
+
+// contains 时间 t 是否在时间段内，Start 和 End 相同表示不限制
+func (w TimeWindow) contains(t time.Time) bool {
+	if w.Start == w.End {
+		return true
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
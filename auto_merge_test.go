@@ -0,0 +1,123 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_Stat_ReclaimableSize(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge-1")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	// 1.覆盖和删除的数据计入可回收的空间
+	for i := 0; i < 50; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	overwritten := db.Stat().ReclaimableSize
+	assert.True(t, overwritten > 0)
+	err = db.Delete(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().ReclaimableSize > overwritten)
+
+	// 2.重启之后重新统计
+	reclaimable := db.Stat().ReclaimableSize
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimable, db2.Stat().ReclaimableSize)
+}
+
+func TestDB_AutoMerge(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge-2")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	opt.AutoMergeRatio = 0.5
+	opt.AutoMergeMinBytes = 1024
+	opt.AutoMergeInterval = 10 * time.Millisecond
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.写入的同时后台自动 merge
+	values := make([][]byte, 500)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 500; j++ {
+			values[j] = utils.RandomValue(64)
+			err := db.Put(utils.GetTestKey(j), values[j])
+			assert.Nil(t, err)
+		}
+	}
+
+	// 2.可回收空间超过阈值之后自动执行 merge，完成之后立即回收，和写入同时进行时被覆盖的重写数据留给之后的 merge
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return db.manifest.state.hintFile != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		reclaimable, total := db.reclaimableStat()
+		return float32(reclaimable)/float32(total) < opt.AutoMergeRatio
+	}, 5*time.Second, 10*time.Millisecond)
+	for j := 0; j < 500; j++ {
+		val, err := db.Get(utils.GetTestKey(j))
+		assert.Nil(t, err)
+		assert.Equal(t, values[j], val)
+	}
+
+	// 3.关闭时停止后台 merge，重启之后数据和可回收的空间不变
+	err = db.Close()
+	assert.Nil(t, err)
+	reclaimable := db.Stat().ReclaimableSize
+	db2, err := Open(opt)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	assert.Equal(t, reclaimable, db2.Stat().ReclaimableSize)
+	for j := 0; j < 500; j++ {
+		val, err := db2.Get(utils.GetTestKey(j))
+		assert.Nil(t, err)
+		assert.Equal(t, values[j], val)
+	}
+}
+
+func TestTimeWindow_Contains(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	// 1.为空时不限制
+	assert.True(t, TimeWindow{}.contains(day.Add(13*time.Hour)))
+
+	// 2.同一天内的时间段
+	w := TimeWindow{Start: 2 * time.Hour, End: 5 * time.Hour}
+	assert.True(t, w.contains(day.Add(3*time.Hour)))
+	assert.False(t, w.contains(day.Add(5*time.Hour)))
+	assert.False(t, w.contains(day.Add(time.Hour)))
+
+	// 3.跨过零点的时间段
+	w = TimeWindow{Start: 22 * time.Hour, End: 2 * time.Hour}
+	assert.True(t, w.contains(day.Add(23*time.Hour)))
+	assert.True(t, w.contains(day.Add(time.Hour)))
+	assert.False(t, w.contains(day.Add(12*time.Hour)))
+}
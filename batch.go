@@ -73,6 +73,25 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	return nil
 }
 
+// Get 读取数据，优先读取批次中暂存的数据
+func (wb *WriteBatch) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	record := wb.pendingWrites[string(key)]
+	wb.mu.Unlock()
+
+	// 批次中没有暂存，从数据库中读取
+	if record == nil {
+		return wb.db.Get(key)
+	}
+	if record.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	return record.Value, nil
+}
+
 // Commit 提交事务，将暂存的数据写到数据文件，并更新内存索引
 func (wb *WriteBatch) Commit() error {
 	wb.mu.Lock()
@@ -89,21 +108,52 @@ func (wb *WriteBatch) Commit() error {
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	if err := wb.db.commitRecords(wb.pendingWrites, wb.options.SyncWrites); err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// commitRecords 以事务的形式将一组数据写入数据文件，并更新内存索引，调用时必须持有 db.mu
+func (db *DB) commitRecords(records map[string]*data.LogRecord, syncWrites bool) error {
+	// 写入之前检查，保证事务中的数据要么全部写入，要么全部不写入
+	checks := make([]*data.LogRecord, 0, len(records))
+	for _, record := range records {
+		if record.Type == data.LogRecordNormal {
+			if err := db.checkKeySize(record.Bucket, record.Key); err != nil {
+				return err
+			}
+		}
+		checks = append(checks, record)
+	}
+	if err := db.checkIndexMemory(checks...); err != nil {
+		return err
+	}
+
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+	// 同一个事务中的数据使用相同的版本号
+	version, timestamp := db.nextVersion()
 
 	// 开始写数据到数据文件中
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+	for _, record := range records {
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(record.Key, seqNo),
+			Value:     record.Value,
+			Type:      record.Type,
+			Bucket:    record.Bucket,
+			Version:   version,
+			Timestamp: timestamp,
 		})
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		positions[bucketKey(record.Bucket, record.Key)] = logRecordPos
 	}
 
 	// 写一条表示事务完成的数据
@@ -111,30 +161,44 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishRecord); err != nil {
+	finishPos, err := db.appendLogRecord(finishRecord)
+	if err != nil {
 		return err
 	}
+	db.markReclaimable(nil, finishPos)
 
 	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if syncWrites && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
 		}
 	}
 
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
+	changes := make([]*data.LogRecord, 0, len(records))
+	for _, record := range records {
+		pos := positions[bucketKey(record.Bucket, record.Key)]
+		db.addHistory(record.Bucket, record.Key, version, timestamp, pos, record.Type == data.LogRecordDeleted)
 		if record.Type == data.LogRecordNormal {
-			wb.db.index.Put(record.Key, pos)
+			db.indexPut(record.Bucket, record.Key, pos)
+			if len(record.Bucket) == 0 {
+				db.updateSecondaryIndexes(record.Key, record.Value)
+			}
 		}
 		if record.Type == data.LogRecordDeleted {
-			wb.db.index.Delete(record.Key)
+			db.indexDelete(record.Bucket, record.Key)
+			db.markReclaimable(record.Bucket, pos)
 		}
+		changes = append(changes, &data.LogRecord{
+			Key:     record.Key,
+			Value:   record.Value,
+			Type:    record.Type,
+			Bucket:  record.Bucket,
+			Version: version,
+		})
 	}
 
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
+	db.watchHub.notify(changes)
 	return nil
 }
 
@@ -0,0 +1,155 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/index"
+)
+
+// BatchIterator 批量写迭代器，按 key 的顺序合并批次中暂存的数据和数据库中的数据
+type BatchIterator struct {
+	indexIter  index.Iterator    // 索引迭代器
+	pending    []*data.LogRecord // 创建迭代器时批次中暂存数据的快照，按遍历顺序排列
+	pendingIdx int               // 当前遍历到的暂存数据下标
+	db         *DB
+	options    IteratorOptions
+	currKey    []byte             // 当前位置的 key
+	currRecord *data.LogRecord    // 当前位置来自暂存数据时不为空
+	currPos    *data.LogRecordPos // 当前位置来自索引时不为空
+	valid      bool
+}
+
+// NewIterator 初始化批量写迭代器，创建之后批次中新的写入不可见
+func (wb *WriteBatch) NewIterator(opts IteratorOptions) *BatchIterator {
+	wb.mu.Lock()
+	pending := make([]*data.LogRecord, 0, len(wb.pendingWrites))
+	for _, record := range wb.pendingWrites {
+		pending = append(pending, record)
+	}
+	wb.mu.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(pending[i].Key, pending[j].Key) > 0
+		}
+		return bytes.Compare(pending[i].Key, pending[j].Key) < 0
+	})
+
+	it := &BatchIterator{
+		indexIter: wb.db.index.Iterator(opts.Reverse),
+		pending:   pending,
+		db:        wb.db,
+		options:   opts,
+	}
+	it.Rewind()
+	return it
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (it *BatchIterator) Rewind() {
+	it.indexIter.Rewind()
+	it.pendingIdx = 0
+	it.settle()
+}
+
+// Seek 根据传入的 key 查找第一个大于(或小于)等于的目标 key，根据这个 key 开始遍历
+func (it *BatchIterator) Seek(key []byte) {
+	it.indexIter.Seek(key)
+	it.pendingIdx = sort.Search(len(it.pending), func(i int) bool {
+		if it.options.Reverse {
+			return bytes.Compare(it.pending[i].Key, key) <= 0
+		}
+		return bytes.Compare(it.pending[i].Key, key) >= 0
+	})
+	it.settle()
+}
+
+// Next 跳转到下一个 key
+func (it *BatchIterator) Next() {
+	if !it.valid {
+		return
+	}
+	if it.currRecord != nil {
+		it.pendingIdx++
+	} else {
+		it.indexIter.Next()
+	}
+	it.settle()
+}
+
+// Valid 是否已经遍历完所有 key，用于退出
+func (it *BatchIterator) Valid() bool {
+	return it.valid
+}
+
+// Key 当前位置的 key 数据
+func (it *BatchIterator) Key() []byte {
+	return it.currKey
+}
+
+// Value 当前位置的 value 数据
+func (it *BatchIterator) Value() ([]byte, error) {
+	if it.currRecord != nil {
+		return it.currRecord.Value, nil
+	}
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	return it.db.getValueByPosition(it.currPos)
+}
+
+// Close 关闭迭代器，释放资源
+func (it *BatchIterator) Close() {
+	it.indexIter.Close()
+	it.pending = nil
+}
+
+// settle 定位到下一个有效的位置，跳过被暂存删除覆盖的 key 和不匹配前缀的 key
+func (it *BatchIterator) settle() {
+	for {
+		indexValid := it.indexIter.Valid()
+		pendingValid := it.pendingIdx < len(it.pending)
+		if !indexValid && !pendingValid {
+			it.valid = false
+			it.currKey, it.currRecord, it.currPos = nil, nil, nil
+			return
+		}
+
+		// 比较两边的 key，暂存数据优先
+		usePending := pendingValid
+		if indexValid && pendingValid {
+			cmp := bytes.Compare(it.indexIter.Key(), it.pending[it.pendingIdx].Key)
+			if cmp == 0 {
+				// 被暂存数据覆盖，跳过索引中的数据
+				it.indexIter.Next()
+				continue
+			}
+			if it.options.Reverse {
+				cmp = -cmp
+			}
+			usePending = cmp > 0
+		}
+
+		if usePending {
+			record := it.pending[it.pendingIdx]
+			if record.Type == data.LogRecordDeleted || !it.hasPrefix(record.Key) {
+				it.pendingIdx++
+				continue
+			}
+			it.currKey, it.currRecord, it.currPos = record.Key, record, nil
+		} else {
+			if !it.hasPrefix(it.indexIter.Key()) {
+				it.indexIter.Next()
+				continue
+			}
+			it.currKey, it.currRecord, it.currPos = it.indexIter.Key(), nil, it.indexIter.Value()
+		}
+		it.valid = true
+		return
+	}
+}
+
+func (it *BatchIterator) hasPrefix(key []byte) bool {
+	return bytes.HasPrefix(key, it.options.Prefix)
+}
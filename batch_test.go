@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
 	"github.com/xavier-tse/bitcask-go/utils"
 )
 
@@ -84,3 +85,135 @@ func TestDB_WriteBatch2(t *testing.T) {
 	// 校验序列号
 	assert.Equal(t, uint64(2), db.seqNo)
 }
+
+func TestWriteBatch_Get(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-3")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("db-1"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("db-2"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(1), []byte("wb-1"))
+	assert.Nil(t, err)
+	err = wb.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	// 1.读取批次中暂存的数据
+	val, err := wb.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("wb-1"), val)
+
+	// 2.读取批次中暂存删除的数据
+	_, err = wb.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 3.批次中没有的数据从数据库读取
+	err = db.Put(utils.GetTestKey(3), []byte("db-3"))
+	assert.Nil(t, err)
+	val, err = wb.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("db-3"), val)
+	_, err = wb.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestWriteBatch_Iterator(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-4")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put([]byte("aa"), []byte("db-aa"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("ac"), []byte("db-ac"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("ba"), []byte("db-ba"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("ab"), []byte("wb-ab"))
+	assert.Nil(t, err)
+	err = wb.Put([]byte("ac"), []byte("wb-ac"))
+	assert.Nil(t, err)
+	err = wb.Delete([]byte("ba"))
+	assert.Nil(t, err)
+	err = wb.Put([]byte("bb"), []byte("wb-bb"))
+	assert.Nil(t, err)
+
+	collect := func(it *BatchIterator) ([]string, []string) {
+		var keys, values []string
+		for ; it.Valid(); it.Next() {
+			val, err := it.Value()
+			assert.Nil(t, err)
+			keys = append(keys, string(it.Key()))
+			values = append(values, string(val))
+		}
+		return keys, values
+	}
+
+	// 1.正向迭代
+	iter1 := wb.NewIterator(DefaultIteratorOptions)
+	keys, values := collect(iter1)
+	assert.Equal(t, []string{"aa", "ab", "ac", "bb"}, keys)
+	assert.Equal(t, []string{"db-aa", "wb-ab", "wb-ac", "wb-bb"}, values)
+	iter1.Seek([]byte("ab1"))
+	keys, _ = collect(iter1)
+	assert.Equal(t, []string{"ac", "bb"}, keys)
+
+	// 2.反向迭代
+	iterOpts1 := DefaultIteratorOptions
+	iterOpts1.Reverse = true
+	iter2 := wb.NewIterator(iterOpts1)
+	keys, _ = collect(iter2)
+	assert.Equal(t, []string{"bb", "ac", "ab", "aa"}, keys)
+	iter2.Seek([]byte("b"))
+	keys, _ = collect(iter2)
+	assert.Equal(t, []string{"ac", "ab", "aa"}, keys)
+
+	// 3.指定了 prefix
+	iterOpts2 := DefaultIteratorOptions
+	iterOpts2.Prefix = []byte("b")
+	iter3 := wb.NewIterator(iterOpts2)
+	keys, _ = collect(iter3)
+	assert.Equal(t, []string{"bb"}, keys)
+	iter3.Close()
+}
+
+func TestDB_CommitRecords_Buckets(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-3")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 不同 keyspace 中相同的 key 在同一个事务中提交，各自指向自己的数据
+	records := map[string]*data.LogRecord{
+		"default": {Key: []byte("name"), Value: []byte("default")},
+		"users":   {Key: []byte("name"), Value: []byte("users"), Bucket: []byte("users")},
+	}
+	db.mu.Lock()
+	err = db.commitRecords(records, false)
+	db.mu.Unlock()
+	assert.Nil(t, err)
+
+	val, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	bucket, err := db.Bucket("users")
+	assert.Nil(t, err)
+	val, err = bucket.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+}
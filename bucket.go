@@ -0,0 +1,173 @@
+package bitcask_go
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/index"
+)
+
+// Bucket 命名的 keyspace，与默认 keyspace 共享数据文件，但拥有独立的内存索引
+type Bucket struct {
+	db   *DB
+	name []byte
+}
+
+// Bucket 获取名称为 name 的 keyspace，keyspace 在第一次写入时创建
+func (db *DB) Bucket(name string) (*Bucket, error) {
+	if len(name) == 0 {
+		return nil, ErrBucketNameIsEmpty
+	}
+	return &Bucket{
+		db:   db,
+		name: []byte(name),
+	}, nil
+}
+
+// DropBucket 删除名称为 name 的 keyspace 中的所有数据，只写入一条删除标记
+func (db *DB) DropBucket(name string) error {
+	if len(name) == 0 {
+		return ErrBucketNameIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// keyspace 不存在，直接返回
+	if _, ok := db.buckets[name]; !ok {
+		return nil
+	}
+
+	logRecord := &data.LogRecord{
+		Key:    logRecordKeyWithSeq(nil, nonTransactionSeqNo),
+		Type:   data.LogRecordBucketDropped,
+		Bucket: []byte(name),
+	}
+	logRecord.Version, logRecord.Timestamp = db.nextVersion()
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	keys := db.dropBucketIndex([]byte(name))
+	db.markReclaimable([]byte(name), pos)
+	changes := make([]*data.LogRecord, 0, len(keys))
+	for _, key := range keys {
+		db.txnTracker.markWritten([]byte(name), key)
+		changes = append(changes, &data.LogRecord{Key: key, Type: data.LogRecordDeleted, Bucket: []byte(name), Version: logRecord.Version})
+	}
+
+	db.watchHub.notify(changes)
+	return nil
+}
+
+// bucketKey 将 keyspace 名称和 key 编码为 map 的 key，不同 keyspace 中相同的 key 不会冲突
+func bucketKey(bucket []byte, key []byte) string {
+	buf := make([]byte, 0, binary.MaxVarintLen64+len(bucket)+len(key))
+	buf = binary.AppendUvarint(buf, uint64(len(bucket)))
+	buf = append(buf, bucket...)
+	buf = append(buf, key...)
+	return string(buf)
+}
+
+// dropBucketIndex 删除 keyspace 的内存索引，其中所有数据都计入可回收的空间，返回被删除的 key，调用时必须持有 db.mu 写锁
+func (db *DB) dropBucketIndex(bucket []byte) [][]byte {
+	idx, ok := db.buckets[string(bucket)]
+	if !ok {
+		return nil
+	}
+	keys := make([][]byte, 0, idx.Size())
+	iterator := idx.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+		db.markReclaimable(bucket, iterator.Value())
+	}
+	iterator.Close()
+	delete(db.buckets, string(bucket))
+	return keys
+}
+
+// Put 写入 Key-Value 数据, Key 不能为空
+func (b *Bucket) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+	return b.db.put(b.name, key, value)
+}
+
+// Get 根据 key 读取数据
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	b.db.mu.RLock()
+	defer b.db.mu.RUnlock()
+	return b.db.get(b.name, key)
+}
+
+// Delete 根据 key 删除数据
+func (b *Bucket) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+	return b.db.delete(b.name, key)
+}
+
+// NewIterator 初始化 keyspace 的迭代器，keyspace 不存在时返回空的迭代器
+func (b *Bucket) NewIterator(opts IteratorOptions) *Iterator {
+	b.db.mu.RLock()
+	defer b.db.mu.RUnlock()
+	idx := b.db.bucketIndex(b.name, false)
+	if idx == nil {
+		return &Iterator{
+			db:        b.db,
+			indexIter: index.NewEmptyIterator(opts.Reverse),
+			options:   opts,
+		}
+	}
+	return b.db.newIterator(idx, opts)
+}
+
+// ListKeys 获取 keyspace 中所有 key
+func (b *Bucket) ListKeys() [][]byte {
+	b.db.mu.RLock()
+	idx := b.db.bucketIndex(b.name, false)
+	b.db.mu.RUnlock()
+	if idx == nil {
+		return nil
+	}
+	return listKeys(idx)
+}
+
+// Fold 获取 keyspace 中所有数据，并执行用户指定操作，函数返回 false 时终止
+func (b *Bucket) Fold(fn func(key []byte, value []byte) bool) error {
+	b.db.mu.RLock()
+	defer b.db.mu.RUnlock()
+
+	idx := b.db.bucketIndex(b.name, false)
+	if idx == nil {
+		return nil
+	}
+	return b.db.fold(context.Background(), idx, fn)
+}
+
+// Stat 获取 keyspace 的统计信息
+func (b *Bucket) Stat() *Stat {
+	b.db.mu.RLock()
+	defer b.db.mu.RUnlock()
+
+	stat := &Stat{ReclaimableSize: b.db.bucketReclaimableSize(b.name)}
+	if idx := b.db.bucketIndex(b.name, false); idx != nil {
+		stat.KeyNum = idx.Size()
+		stat.IndexMemory = idx.MemoryUsage()
+	}
+	return stat
+}
+
+// Watch 订阅 keyspace 中以 prefix 为前缀的 key 的变更
+func (b *Bucket) Watch(ctx context.Context, prefix []byte) <-chan *WatchResponse {
+	return b.db.watch(ctx, b.name, prefix)
+}
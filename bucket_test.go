@@ -0,0 +1,159 @@
+package bitcask_go
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_Bucket(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bucket-1")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	_, err = db.Bucket("")
+	assert.Equal(t, ErrBucketNameIsEmpty, err)
+
+	users, err := db.Bucket("users")
+	assert.Nil(t, err)
+	orders, err := db.Bucket("orders")
+	assert.Nil(t, err)
+
+	// 1.不同 keyspace 中相同的 key 互不影响
+	err = db.Put(utils.GetTestKey(1), []byte("default"))
+	assert.Nil(t, err)
+	err = users.Put(utils.GetTestKey(1), []byte("users"))
+	assert.Nil(t, err)
+	err = users.Put(utils.GetTestKey(2), []byte("users"))
+	assert.Nil(t, err)
+	err = orders.Put(utils.GetTestKey(1), []byte("orders"))
+	assert.Nil(t, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	val, err = orders.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("orders"), val)
+	_, err = orders.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.统计信息和迭代器
+	assert.Equal(t, 1, db.Stat().KeyNum)
+	assert.Equal(t, 2, users.Stat().KeyNum)
+	assert.Equal(t, 2, len(users.ListKeys()))
+	iter := users.NewIterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("users"), val)
+	}
+
+	// 不存在的 keyspace 返回空的迭代器，不会创建 keyspace
+	empty, _ := db.Bucket("empty")
+	iter = empty.NewIterator(DefaultIteratorOptions)
+	assert.False(t, iter.Valid())
+	iter.Close()
+	_, ok := db.buckets["empty"]
+	assert.False(t, ok)
+
+	// 3.删除数据，只统计 keyspace 自己可以回收的空间
+	assert.Equal(t, int64(0), orders.Stat().ReclaimableSize)
+	err = orders.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = orders.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, orders.Stat().ReclaimableSize > 0)
+	assert.Equal(t, int64(0), users.Stat().ReclaimableSize)
+	assert.Equal(t, orders.Stat().ReclaimableSize, db.Stat().ReclaimableSize)
+
+	// 4.重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	users2, _ := db2.Bucket("users")
+	orders2, _ := db2.Bucket("orders")
+	assert.Equal(t, 1, db2.Stat().KeyNum)
+	assert.Equal(t, 2, users2.Stat().KeyNum)
+	assert.Equal(t, 0, orders2.Stat().KeyNum)
+}
+
+func TestDB_DropBucket(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bucket-2")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, _ := db.Bucket("users")
+	tenant, _ := db.Bucket("tenant")
+	for i := 0; i < 500; i++ {
+		err := users.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+		err = tenant.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	// 1.删除整个 keyspace，订阅者收到所有 key 的删除事件
+	ch := tenant.Watch(context.Background(), nil)
+	err = db.DropBucket("tenant")
+	assert.Nil(t, err)
+	resp := <-ch
+	assert.Equal(t, 500, len(resp.Events))
+	assert.Equal(t, WatchEventDelete, resp.Events[0].Type)
+	assert.Equal(t, utils.GetTestKey(0), resp.Events[0].Key)
+	assert.Equal(t, 0, tenant.Stat().KeyNum)
+	assert.Equal(t, 0, len(tenant.ListKeys()))
+	_, err = tenant.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 500, users.Stat().KeyNum)
+	assert.True(t, tenant.Stat().ReclaimableSize > 0)
+	assert.Equal(t, int64(0), users.Stat().ReclaimableSize)
+
+	// 2.删除之后重新写入
+	err = tenant.Put(utils.GetTestKey(1), []byte("new"))
+	assert.Nil(t, err)
+
+	// 3.重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	users2, _ := db2.Bucket("users")
+	tenant2, _ := db2.Bucket("tenant")
+	assert.Equal(t, 500, users2.Stat().KeyNum)
+	assert.Equal(t, 1, tenant2.Stat().KeyNum)
+
+	// 4.merge 之后从 hint 文件重建每个 keyspace 的索引
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opt)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	users3, _ := db3.Bucket("users")
+	tenant3, _ := db3.Bucket("tenant")
+	assert.Equal(t, 500, users3.Stat().KeyNum)
+	assert.Equal(t, 1, tenant3.Stat().KeyNum)
+	val, err := tenant3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	assert.Equal(t, 0, db3.Stat().KeyNum)
+}
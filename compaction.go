@@ -0,0 +1,111 @@
+package bitcask_go
+
+import (
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// CompactionDecision 压缩过滤器对一条数据的处理方式
+type CompactionDecision int8
+
+const (
+	// CompactionKeep 保留原来的数据
+	CompactionKeep CompactionDecision = iota
+
+	// CompactionDrop 丢弃数据，merge 完成之后 key 从索引中删除，订阅者收到删除事件
+	CompactionDrop
+
+	// CompactionRewrite 使用过滤器返回的新 value 替换原来的数据
+	// 新的数据保留原来的版本号，merge 完成之前原来的数据仍然在数据文件中，重启时同一个版本号以最后写入的数据为准
+	CompactionRewrite
+)
+
+// CompactionEntry 传给压缩过滤器的一条有效数据
+type CompactionEntry struct {
+	Bucket    []byte // 默认 keyspace 为空
+	Key       []byte
+	Value     []byte
+	Version   uint64
+	Timestamp int64
+}
+
+// CompactionFilter 压缩过滤器，merge 重写每条有效数据之前调用，返回处理方式以及 CompactionRewrite 时的新 value
+// 过滤器在不持有锁的情况下调用，不能修改 entry 中的数据
+type CompactionFilter func(entry *CompactionEntry) (CompactionDecision, []byte)
+
+// compactionDrop 被过滤器丢弃的数据
+type compactionDrop struct {
+	bucket []byte
+	key    []byte
+	pos    *data.LogRecordPos
+}
+
+// applyCompactionFilter 对 merge 中的一条有效数据调用压缩过滤器
+// 返回 true 表示数据需要按原样重写，被丢弃的数据记录到 drops 中，等 merge 完成之后再从索引中删除
+func (db *DB) applyCompactionFilter(logRecord *data.LogRecord, key []byte, pos *data.LogRecordPos, drops *[]*compactionDrop) (bool, error) {
+	filter := db.options.CompactionFilter
+	if filter == nil {
+		return true, nil
+	}
+
+	decision, newValue := filter(&CompactionEntry{
+		Bucket:    logRecord.Bucket,
+		Key:       key,
+		Value:     logRecord.Value,
+		Version:   logRecord.Version,
+		Timestamp: logRecord.Timestamp,
+	})
+	switch decision {
+	case CompactionDrop:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if !db.isLivePosition(logRecord.Bucket, key, pos) {
+			return false, nil
+		}
+		// 保留了历史版本的 key 直接写入删除记录，否则 merge 之后更早的版本会重新生效
+		if len(db.history[string(key)]) > 0 && len(logRecord.Bucket) == 0 {
+			return false, db.delete(nil, key)
+		}
+		*drops = append(*drops, &compactionDrop{bucket: logRecord.Bucket, key: key, pos: pos})
+		return false, nil
+	case CompactionRewrite:
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if !db.isLivePosition(logRecord.Bucket, key, pos) {
+			return false, nil
+		}
+		// 新的数据写入活跃文件，保留原来的版本号，原来的数据不再有效
+		record := &data.LogRecord{
+			Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Value:     newValue,
+			Type:      data.LogRecordNormal,
+			Bucket:    logRecord.Bucket,
+			Version:   logRecord.Version,
+			Timestamp: logRecord.Timestamp,
+		}
+		newPos, err := db.appendLogRecord(record)
+		if err != nil {
+			return false, err
+		}
+		if ok := db.indexPut(logRecord.Bucket, key, newPos); !ok {
+			return false, ErrIndexUpdateFailed
+		}
+		if len(logRecord.Bucket) == 0 {
+			db.updateSecondaryIndexes(key, newValue)
+		}
+		db.replaceHistoryPos(logRecord.Bucket, key, logRecord.Version, newPos)
+		db.watchHub.notify([]*data.LogRecord{{Key: key, Value: newValue, Bucket: logRecord.Bucket, Version: logRecord.Version}})
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// isLivePosition 索引中 key 的位置是否仍然为 pos，调用时必须持有 db.mu
+func (db *DB) isLivePosition(bucket []byte, key []byte, pos *data.LogRecordPos) bool {
+	idx := db.bucketIndex(bucket, false)
+	if idx == nil {
+		return false
+	}
+	curr := idx.Get(key)
+	return curr != nil && curr.Fid == pos.Fid && curr.Offset == pos.Offset
+}
@@ -0,0 +1,83 @@
+package bitcask_go
+
+import "bytes"
+
+// PutIfAbsent key 不存在时写入数据，返回是否写入成功
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if pos := db.index.Get(key); pos != nil {
+		return false, nil
+	}
+	if err := db.put(nil, key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap key 当前的值等于 expected 时写入新的值，返回是否写入成功
+// key 不存在时返回 ErrKeyNotFound
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldValue, err := db.get(nil, key)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(oldValue, expected) {
+		return false, nil
+	}
+	if err := db.put(nil, key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetAndSet 写入新的值，并返回写入之前的值，key 之前不存在时 loaded 为 false
+func (db *DB) GetAndSet(key []byte, value []byte) (oldValue []byte, loaded bool, err error) {
+	if len(key) == 0 {
+		return nil, false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldValue, err = db.get(nil, key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, false, err
+	}
+	loaded = err == nil
+	if err := db.put(nil, key, value); err != nil {
+		return nil, false, err
+	}
+	return oldValue, loaded, nil
+}
+
+// DeleteIfEquals key 当前的值等于 expected 时删除数据，返回是否删除成功
+// key 不存在时返回 ErrKeyNotFound
+func (db *DB) DeleteIfEquals(key []byte, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldValue, err := db.get(nil, key)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(oldValue, expected) {
+		return false, nil
+	}
+	if err := db.delete(nil, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
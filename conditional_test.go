@@ -0,0 +1,140 @@
+package bitcask_go
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在时写入
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 2.key 已经存在
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 3.key 为空
+	_, err = db.PutIfAbsent(nil, []byte("a"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 4.并发写入只有一个成功
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		value := utils.RandomValue(10)
+		go func() {
+			defer wg.Done()
+			ok, err := db.PutIfAbsent(utils.GetTestKey(2), value)
+			assert.Nil(t, err)
+			if ok {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	_, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.值不相等
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err := db.CompareAndSwap(utils.GetTestKey(1), []byte("x"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.值相等
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
+
+func TestDB_GetAndSet(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get-and-set")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	old, loaded, err := db.GetAndSet(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, loaded)
+	assert.Nil(t, old)
+
+	// 2.key 已经存在
+	old, loaded, err = db.GetAndSet(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, []byte("a"), old)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
+
+func TestDB_DeleteIfEquals(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-if-equals")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	_, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.值不相等
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err := db.DeleteIfEquals(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.值相等
+	ok, err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,61 @@
+package data
+
+// CombineCRC 根据 crc1 = crc(A)、crc2 = crc(B) 和 B 的长度计算 crc(A+B)，使用 IEEE 多项式
+// 算法来自 zlib 的 crc32_combine
+func CombineCRC(crc1 uint32, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	var even, odd [32]uint32
+
+	// odd 为一个 0 bit 的运算矩阵
+	odd[0] = 0xedb88320
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+
+	// even 为两个 0 bit 的运算矩阵，odd 为四个 0 bit 的运算矩阵
+	gf2MatrixSquare(even[:], odd[:])
+	gf2MatrixSquare(odd[:], even[:])
+
+	// 每次循环对 crc1 追加 len2 个 0 字节，第一次为一个字节的运算
+	for {
+		gf2MatrixSquare(even[:], odd[:])
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(even[:], crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(odd[:], even[:])
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(odd[:], crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat []uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square []uint32, mat []uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
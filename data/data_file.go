@@ -16,8 +16,11 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
-	HintFileName          = "hint-index"
+	HintFileNameSuffix    = ".hint"
+	HintFileName          = "hint-index" // 旧版本 merge 生成的 hint 文件
 	MergeFinishedFileName = "merge-finished"
+	ManifestFileName      = "MANIFEST"
+	BPTreeIndexFileName   = "bptree-index" // 磁盘 B+ 树索引文件
 )
 
 type DataFile struct {
@@ -32,9 +35,15 @@ func OpenDataFile(dirPath string, fileId uint32) (*DataFile, error) {
 	return newDataFile(fileName, fileId)
 }
 
-// OpenHintFile 打开 hint 索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
-	fileName := filepath.Join(dirPath, HintFileName)
+// OpenHintFile 打开名称为 name 的 hint 索引文件
+func OpenHintFile(dirPath string, name string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, name)
+	return newDataFile(fileName, 0)
+}
+
+// OpenManifestFile 打开记录有效文件集合的 manifest 文件
+func OpenManifestFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ManifestFileName)
 	return newDataFile(fileName, 0)
 }
 
@@ -48,14 +57,24 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+// GetHintFileName 获取 merge 生成的 hint 文件名称，使用和数据文件相同的 id 空间，不包括目录
+func GetHintFileName(fileId uint32) string {
+	return fmt.Sprintf("%09d", fileId) + HintFileNameSuffix
+}
+
 func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
 	ioManager, err := fio.NewIOManager(fileName)
 	if err != nil {
 		return nil, err
 	}
+	// 已有的文件从末尾开始写入
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
 	return &DataFile{
 		FileId:    fileId,
-		WriteOff:  0,
+		WriteOff:  size,
 		IoManager: ioManager,
 	}, nil
 }
@@ -112,9 +131,78 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, ErrInvalidCRC
 	}
 
+	// 解出 key 中的附加信息
+	decodeRecordKey(logRecord)
+
 	return logRecord, recordSize, nil
 }
 
+// LogRecordValueReader 流式读取 LogRecord 的 value，读取到末尾时校验 crc
+type LogRecordValueReader struct {
+	Type        LogRecordType
+	Size        int64 // value 的长度
+	reader      *io.SectionReader
+	crc         uint32 // 已经读取部分的 crc
+	expectedCrc uint32 // header 中存储的 crc
+}
+
+// NewLogRecordValueReader 根据 offset 读取 LogRecord 的 header 和 key，返回 value 的流式读取器
+func (df *DataFile) NewLogRecordValueReader(offset int64) (*LogRecordValueReader, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if offset+maxLogRecordHeaderSize > fileSize {
+		headerBytes = fileSize - offset
+	}
+	headerBuf, err := df.readNBytes(headerBytes, offset)
+	if err != nil {
+		return nil, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil {
+		return nil, io.EOF
+	}
+
+	// crc 先覆盖 header 和 key，value 在读取时继续计算
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	keyBuf, err := df.readNBytes(keySize, offset+headerSize)
+	if err != nil {
+		return nil, err
+	}
+	crc := crc32.ChecksumIEEE(headerBuf[crc32.Size:headerSize])
+	crc = crc32.Update(crc, crc32.IEEETable, keyBuf)
+
+	return &LogRecordValueReader{
+		Type:        header.recordType &^ logRecordFlagMask,
+		Size:        valueSize,
+		reader:      io.NewSectionReader(ioManagerReaderAt{df.IoManager}, offset+headerSize+keySize, valueSize),
+		crc:         crc,
+		expectedCrc: header.crc,
+	}, nil
+}
+
+// Read 读取 value，读取到末尾时 crc 校验失败返回 ErrInvalidCRC
+func (vr *LogRecordValueReader) Read(p []byte) (int, error) {
+	n, err := vr.reader.Read(p)
+	vr.crc = crc32.Update(vr.crc, crc32.IEEETable, p[:n])
+	if err == io.EOF && vr.crc != vr.expectedCrc {
+		return n, ErrInvalidCRC
+	}
+	return n, err
+}
+
+// ioManagerReaderAt 将 IOManager 适配为 io.ReaderAt
+type ioManagerReaderAt struct {
+	ioManager fio.IOManager
+}
+
+func (r ioManagerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.ioManager.Read(p, off)
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
@@ -124,16 +212,29 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
-// WriteHintLogRecord 将索引信息写入 hint 文件
-func (df *DataFile) WriteHintLogRecord(key []byte, pos *LogRecordPos) error {
+// WriteHintLogRecord 将索引信息写入 hint 文件，保留 LogRecord 的 keyspace 和版本信息
+func (df *DataFile) WriteHintLogRecord(logRecord *LogRecord, pos *LogRecordPos) error {
 	record := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+		Key:       logRecord.Key,
+		Value:     EncodeLogRecordPos(pos),
+		Type:      logRecord.Type,
+		Bucket:    logRecord.Bucket,
+		Version:   logRecord.Version,
+		Timestamp: logRecord.Timestamp,
 	}
 	encRecord, _ := EncodeLogRecord(record)
 	return df.Write(encRecord)
 }
 
+// Truncate 丢弃 offset 之后写入的数据
+func (df *DataFile) Truncate(offset int64) error {
+	if err := df.IoManager.Truncate(offset); err != nil {
+		return err
+	}
+	df.WriteOff = offset
+	return nil
+}
+
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
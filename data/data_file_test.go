@@ -106,3 +106,42 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_ReadLogRecord_Bucket(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file-bucket")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	// 带有 keyspace 名称的 LogRecord
+	rec1 := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask kv go"),
+		Bucket: []byte("users"),
+	}
+	res1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(res1)
+	assert.Nil(t, err)
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1, readRec1)
+	assert.Equal(t, size1, readSize1)
+
+	// 删除整个 keyspace 的标记
+	rec2 := &LogRecord{
+		Type:   LogRecordBucketDropped,
+		Bucket: []byte("users"),
+	}
+	res2, size2 := EncodeLogRecord(rec2)
+	err = dataFile.Write(res2)
+	assert.Nil(t, err)
+
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordBucketDropped, readRec2.Type)
+	assert.Equal(t, []byte("users"), readRec2.Bucket)
+	assert.Equal(t, 0, len(readRec2.Key))
+	assert.Equal(t, size2, readSize2)
+}
@@ -11,6 +11,19 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordFinished
+	// LogRecordRangeDeleted 范围删除标记，Key 为范围起点，Value 为范围终点（不包含），终点为空表示直到最后
+	LogRecordRangeDeleted
+	// LogRecordBucketDropped 删除整个 keyspace 的标记
+	LogRecordBucketDropped
+)
+
+const (
+	// logRecordBucketFlag type 的最高位标识 key 中带有 keyspace 名称
+	logRecordBucketFlag LogRecordType = 0x80
+	// logRecordVersionFlag 标识 key 中带有写入版本号和时间戳
+	logRecordVersionFlag LogRecordType = 0x40
+
+	logRecordFlagMask = logRecordBucketFlag | logRecordVersionFlag
 )
 
 // crc type keySize valueSize
@@ -19,9 +32,12 @@ const (
 const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
 
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key       []byte
+	Value     []byte
+	Type      LogRecordType
+	Bucket    []byte // 所属的 keyspace，为空表示默认 keyspace
+	Version   uint64 // 写入版本号，为 0 表示没有版本号
+	Timestamp int64  // 写入时间，Unix 纳秒，只在有版本号时存储
 }
 
 // LogRecord 的头部信息
@@ -35,6 +51,7 @@ type logRecordHeader struct {
 type LogRecordPos struct {
 	Fid    uint32
 	Offset int64
+	Size   uint32 // LogRecord 在数据文件中的大小
 }
 
 // TransactionRecord 暂存事务相关的数据
@@ -45,21 +62,12 @@ type TransactionRecord struct {
 
 // EncodeLogRecord 对 LogRecord 编码，返回字节数组和长度
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
-	header := make([]byte, maxLogRecordHeaderSize)
-
-	// 第5个字节存储 type
-	header[4] = logRecord.Type
-	index := 5
-	// 5个字节之后存储的是 key 和 value 的长度信息
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	header := EncodeLogRecordHeader(logRecord, int64(len(logRecord.Value)))
 
-	size := index + len(logRecord.Key) + len(logRecord.Value)
+	size := len(header) + len(logRecord.Value)
 	encBytes := make([]byte, size)
-
-	copy(encBytes[:index], header[:index])
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encBytes, header)
+	copy(encBytes[len(header):], logRecord.Value)
 
 	// 对整个LogRecord 进行 crc 校验
 	crc := crc32.ChecksumIEEE(encBytes[4:])
@@ -68,12 +76,32 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
+// EncodeLogRecordHeader 对 LogRecord 的 header 和 key 编码，value 由调用方在之后写入
+// 前4个字节为 crc 预留，调用方需要用 header、key 和 value 计算出 crc 之后填充
+func EncodeLogRecordHeader(logRecord *LogRecord, valueSize int64) []byte {
+	header := make([]byte, maxLogRecordHeaderSize)
+
+	// 第5个字节存储 type，高位存储 key 中附加信息的标识
+	flags, key := encodeRecordKey(logRecord)
+	header[4] = logRecord.Type | flags
+	index := 5
+	// 5个字节之后存储的是 key 和 value 的长度信息
+	index += binary.PutVarint(header[index:], int64(len(key)))
+	index += binary.PutVarint(header[index:], valueSize)
+
+	encBytes := make([]byte, index+len(key))
+	copy(encBytes[:index], header[:index])
+	copy(encBytes[index:], key)
+	return encBytes
+}
+
 // EncodeLogRecordPos 对位置信息编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
 	index := 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
 	return buf[:index]
 }
 
@@ -82,10 +110,17 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index := 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	// 旧版本的 hint 文件中没有记录大小
+	var size int64
+	if index < len(buf) {
+		size, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
+		Size:   uint32(size),
 	}
 }
 
@@ -123,3 +158,49 @@ func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 
 	return crc
 }
+
+// encodeRecordKey 对 key 和附加信息编码，返回 type 的标识位和编码后的 key
+// 编码格式: [version timestamp] [bucketSize bucket] key，没有的部分不存储
+func encodeRecordKey(logRecord *LogRecord) (LogRecordType, []byte) {
+	if logRecord.Version == 0 && len(logRecord.Bucket) == 0 {
+		return 0, logRecord.Key
+	}
+
+	var flags LogRecordType
+	buf := make([]byte, binary.MaxVarintLen64*2+binary.MaxVarintLen32+len(logRecord.Bucket)+len(logRecord.Key))
+	index := 0
+	if logRecord.Version > 0 {
+		flags |= logRecordVersionFlag
+		index += binary.PutUvarint(buf[index:], logRecord.Version)
+		index += binary.PutVarint(buf[index:], logRecord.Timestamp)
+	}
+	if len(logRecord.Bucket) > 0 {
+		flags |= logRecordBucketFlag
+		index += binary.PutUvarint(buf[index:], uint64(len(logRecord.Bucket)))
+		index += copy(buf[index:], logRecord.Bucket)
+	}
+	index += copy(buf[index:], logRecord.Key)
+	return flags, buf[:index]
+}
+
+// decodeRecordKey 根据 type 中的标识位解码 key 中的附加信息
+func decodeRecordKey(logRecord *LogRecord) {
+	flags := logRecord.Type & logRecordFlagMask
+	logRecord.Type &^= logRecordFlagMask
+
+	key := logRecord.Key
+	if flags&logRecordVersionFlag != 0 {
+		version, n := binary.Uvarint(key)
+		key = key[n:]
+		timestamp, n := binary.Varint(key)
+		key = key[n:]
+		logRecord.Version, logRecord.Timestamp = version, timestamp
+	}
+	if flags&logRecordBucketFlag != 0 {
+		bucketSize, n := binary.Uvarint(key)
+		key = key[n:]
+		logRecord.Bucket = key[:bucketSize]
+		key = key[bucketSize:]
+	}
+	logRecord.Key = key
+}
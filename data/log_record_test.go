@@ -97,3 +97,37 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeRecordKey(t *testing.T) {
+	// 没有附加信息
+	rec1 := &LogRecord{Key: []byte("name")}
+	flags1, key1 := encodeRecordKey(rec1)
+	assert.Equal(t, LogRecordType(0), flags1)
+	assert.Equal(t, []byte("name"), key1)
+
+	// 带有版本号、时间戳和 keyspace
+	rec2 := &LogRecord{
+		Key:       []byte("name"),
+		Type:      LogRecordDeleted,
+		Bucket:    []byte("users"),
+		Version:   300,
+		Timestamp: 1700000000000000000,
+	}
+	flags2, key2 := encodeRecordKey(rec2)
+	assert.Equal(t, logRecordFlagMask, flags2)
+
+	decRec2 := &LogRecord{Key: key2, Type: LogRecordDeleted | flags2}
+	decodeRecordKey(decRec2)
+	assert.Equal(t, rec2, decRec2)
+}
+
+func TestCombineCRC(t *testing.T) {
+	a := []byte("bitcask-go-key")
+	b := []byte("bitcask-go-value-with-a-longer-content")
+	crcA := crc32.ChecksumIEEE(a)
+	crcB := crc32.ChecksumIEEE(b)
+	assert.Equal(t, crc32.ChecksumIEEE(append(a, b...)), CombineCRC(crcA, crcB, int64(len(b))))
+
+	// 第二部分为空
+	assert.Equal(t, crcA, CombineCRC(crcA, crc32.ChecksumIEEE(nil), 0))
+}
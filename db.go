@@ -1,31 +1,72 @@
 package bitcask_go
 
 import (
+	"context"
 	"errors"
-	"io"
 	"os"
-	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/xavier-tse/bitcask-go/data"
 	"github.com/xavier-tse/bitcask-go/index"
+	"github.com/xavier-tse/bitcask-go/utils"
 )
 
+// Stat 存储引擎统计信息
+type Stat struct {
+	KeyNum          int   // key 的总数
+	ReclaimableSize int64 // 所有数据文件中可以被 merge 回收的字节数
+	IndexMemory     int64 // 索引占用内存的估计值，默认 keyspace 包括保留的历史版本和二级索引
+}
+
+// RecordMeta 数据的元信息
+type RecordMeta struct {
+	Version   uint64 // 写入版本号，全局单调递增，旧版本写入的数据为 0
+	Timestamp int64  // 写入时间，Unix 纳秒
+	Size      int64  // LogRecord 在数据文件中的大小
+	Fid       uint32 // 所在的数据文件 id
+}
+
 type DB struct {
-	options    Options
-	mu         *sync.RWMutex
-	fileIds    []int                     // 文件id, 仅用于加载索引
-	activeFile *data.DataFile            // 当前的活跃数据文件，可以写入
-	olderFiles map[uint32]*data.DataFile // 旧的数据文件，只能读
-	index      index.Indexer             // 内存索引
-	seqNo      uint64                    // 事务序列号，全局递增
-	isMerging  bool                      // 是否正在 merge
+	options         Options
+	mu              *sync.RWMutex
+	activeFile      *data.DataFile            // 当前的活跃数据文件，可以写入
+	olderFiles      map[uint32]*data.DataFile // 旧的数据文件，只能读
+	index           index.Indexer             // 内存索引
+	seqNo           uint64                    // 事务序列号，全局递增
+	version         uint64                    // 写入版本号，全局递增，每条数据都会持久化
+	isMerging       bool                      // 是否正在 merge
+	buckets         map[string]index.Indexer  // 命名 keyspace 的内存索引
+	txnTracker      *txnTracker               // 活跃事务的冲突检测信息
+	watchHub        *watchHub                 // 变更事件订阅
+	history         map[string][]*versionPos  // 默认 keyspace 中每个 key 保留的历史版本，按版本号从旧到新排列
+	historyExpiries []historyExpiry           // 按写入顺序排列的历史版本，用于按保留时间清理
+	historyMemory   int64                     // 历史版本占用内存的估计值
+	manifest        *manifest                 // 有效的文件集合
+	openTime        time.Time                 // 开始打开数据库的时间
+	recovery        RecoveryReport            // 打开数据库时的加载统计
+
+	reclaimable       map[uint32]int64            // 每个数据文件中已经失效、可以被 merge 回收的字节数
+	bucketReclaimable map[string]map[uint32]int64 // 命名 keyspace 在每个数据文件中可以被回收的字节数，包含在 reclaimable 中
+	stopMerge         context.CancelFunc          // 停止后台自动 merge
+	mergeDone         chan struct{}               // 后台自动 merge 退出时关闭
+	ioLimiter         *utils.RateLimiter          // 后台任务共享的读写限速器，为空表示不限速
+
+	diskIndex      *index.BPlusTree   // 磁盘 B+ 树索引，和 index 相同，其他索引类型时为 nil
+	indexLoaded    bool               // 索引是否已经加载完成，加载完成之后才可以持久化磁盘索引
+	loadedHintFile string             // 磁盘索引中的位置对应的 hint 文件，merge 生效时更新
+	stopCheckpoint context.CancelFunc // 停止后台持久化磁盘索引
+	checkpointDone chan struct{}      // 后台持久化磁盘索引的 goroutine 退出时关闭
+
+	secondary map[string]*secondaryIndex // 默认 keyspace 的二级索引，打开数据库时从数据重建
 }
 
 func Open(options Options) (*DB, error) {
+	return OpenContext(context.Background(), options)
+}
+
+// OpenContext 同 Open，ctx 结束时停止加载索引，关闭已经打开的文件并返回 ctx 的错误
+func OpenContext(ctx context.Context, options Options) (*DB, error) {
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
@@ -41,39 +82,126 @@ func Open(options Options) (*DB, error) {
 		options:    options,
 		mu:         new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType),
+		buckets:    make(map[string]index.Indexer),
+		txnTracker: newTxnTracker(),
+		watchHub:   newWatchHub(),
+		history:    make(map[string][]*versionPos),
+
+		reclaimable:       make(map[uint32]int64),
+		bucketReclaimable: make(map[string]map[uint32]int64),
+		ioLimiter:         utils.NewRateLimiter(options.BackgroundBytesPerSec),
+		openTime:          time.Now(),
+	}
+	// 磁盘索引需要在加载文件集合之后打开
+	if options.IndexType != BPTree {
+		db.index = db.newIndexer(options.IndexType)
 	}
 
-	// 加载 merge 数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	// 加载有效的文件集合
+	phaseStart := time.Now()
+	if err := db.loadManifest(); err != nil {
 		return nil, err
 	}
 
 	// 加载数据文件
 	if err := db.loadDataFiles(); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
-	// 从 hint 索引文件中加载索引
-	if err := db.loadIndexFromHintFile(); err != nil {
+	// 删除不在文件集合中的文件
+	if err := db.removeObsoleteFiles(); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
+	db.recovery.ManifestTime = time.Since(phaseStart)
+	db.reportRecoveryProgress(RecoveryProgress{Phase: RecoveryPhaseManifest, FilesDone: 1, FilesTotal: 1})
+
+	// 打开磁盘索引，checkpoint 可以使用时只需要重放之后写入的数据
+	replayFrom, err := db.openDiskIndex()
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	// 从 hint 索引文件中加载索引
+	phaseStart = time.Now()
+	if !db.recovery.IndexCheckpointUsed {
+		if err := db.loadIndexFromHintFile(ctx); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	db.recovery.HintTime = time.Since(phaseStart)
+
 	// 从数据文件中加载索引
-	if err := db.loadIndexFromDataFiles(); err != nil {
+	phaseStart = time.Now()
+	if err := db.loadIndexFromDataFiles(ctx, replayFrom); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
+	db.recovery.DataScanTime = time.Since(phaseStart)
+	db.indexLoaded = true
+
+	// 重建二级索引
+	if err := db.loadSecondaryIndexes(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	// 被 merge 丢弃的数据可能有更大的版本号，使用上一次记录的版本号
+	db.version = max(db.version, db.manifest.state.version)
+
+	// 重写 manifest，只保留当前的文件集合
+	state := db.manifest.state.clone()
+	state.seqNo = db.seqNo
+	state.version = db.version
+	if err := db.manifest.rewrite(state); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	// 持久化加载完成的磁盘索引，下次打开时不需要重放
+	if err := db.checkpointIndex(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	db.recovery.TotalTime = time.Since(db.openTime)
+
+	// 启动后台自动 merge 和磁盘索引持久化
+	db.startAutoMerge()
+	db.startIndexCheckpoint()
 
 	return db, nil
 }
 
 // Close 关闭数据库
 func (db *DB) Close() error {
+	// 先等待后台任务退出，后台任务需要获取 db.mu
+	db.stopAutoMerge()
+	db.stopIndexCheckpoint()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 关闭所有订阅者
+	db.watchHub.closeAll()
+
+	// 持久化并关闭磁盘索引，需要在关闭数据文件之前
+	if err := db.closeDiskIndex(); err != nil {
+		return err
+	}
+
+	// 关闭 manifest 文件
+	if err := db.manifest.close(); err != nil {
+		return err
+	}
+
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
 
 	// 关闭当前活跃数据文件
 	if err := db.activeFile.Close(); err != nil {
@@ -104,49 +232,83 @@ func (db *DB) Put(key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.put(nil, key, value)
+}
+
+// Delete 根据 key 删除数据
+func (db *DB) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.delete(nil, key)
+}
 
+// put 向 bucket 对应的 keyspace 写入数据并更新内存索引，调用时必须持有 db.mu
+func (db *DB) put(bucket []byte, key []byte, value []byte) error {
+	if err := db.checkKeySize(bucket, key); err != nil {
+		return err
+	}
+	if err := db.checkIndexMemory(&data.LogRecord{Key: key, Value: value, Bucket: bucket}); err != nil {
+		return err
+	}
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Bucket: bucket,
 	}
+	logRecord.Version, logRecord.Timestamp = db.nextVersion()
 
 	// 追加写入到当前活跃数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
+	if ok := db.indexPut(bucket, key, pos); !ok {
 		return ErrIndexUpdateFailed
 	}
+	if len(bucket) == 0 {
+		db.updateSecondaryIndexes(key, value)
+	}
+	db.addHistory(bucket, key, logRecord.Version, logRecord.Timestamp, pos, false)
+
+	db.watchHub.notify([]*data.LogRecord{{Key: key, Value: value, Bucket: bucket, Version: logRecord.Version}})
 	return nil
 }
 
-// Delete 根据 key 删除数据
-func (db *DB) Delete(key []byte) error {
-	if len(key) == 0 {
-		return ErrKeyIsEmpty
-	}
-
+// delete 从 bucket 对应的 keyspace 删除数据并更新内存索引，调用时必须持有 db.mu
+func (db *DB) delete(bucket []byte, key []byte) error {
 	// key 不存在，直接返回
-	if pos := db.index.Get(key); pos == nil {
+	idx := db.bucketIndex(bucket, false)
+	if idx == nil || idx.Get(key) == nil {
 		return nil
 	}
 
 	logRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type: data.LogRecordDeleted,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:   data.LogRecordDeleted,
+		Bucket: bucket,
 	}
-	_, err := db.appendLogRecordWithLock(logRecord)
+	logRecord.Version, logRecord.Timestamp = db.nextVersion()
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
-	if ok := db.index.Delete(key); !ok {
+	if ok := db.indexDelete(bucket, key); !ok {
 		return ErrIndexUpdateFailed
 	}
+	// 删除记录本身也可以被回收
+	db.markReclaimable(bucket, pos)
+	db.addHistory(bucket, key, logRecord.Version, logRecord.Timestamp, pos, true)
+
+	db.watchHub.notify([]*data.LogRecord{{Key: key, Type: data.LogRecordDeleted, Bucket: bucket, Version: logRecord.Version}})
 	return nil
 }
 
@@ -157,8 +319,31 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	return db.get(nil, key)
+}
+
+// GetWithMeta 根据 key 读取数据和它的元信息
+func (db *DB) GetWithMeta(key []byte) ([]byte, *RecordMeta, error) {
+	if len(key) == 0 {
+		return nil, nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
 	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+	return db.getValueWithMeta(logRecordPos)
+}
+
+// get 从 bucket 对应的 keyspace 读取数据，调用时必须持有 db.mu
+func (db *DB) get(bucket []byte, key []byte) ([]byte, error) {
+	idx := db.bucketIndex(bucket, false)
+	if idx == nil {
+		return nil, ErrKeyNotFound
+	}
+	logRecordPos := idx.Get(key)
 	// key 不在内存索引中，说明 key 不存在
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
@@ -170,23 +355,211 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 // ListKeys 获取数据库中所有 key
 func (db *DB) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
-	}
-	return keys
+	return listKeys(db.index)
 }
 
 // Fold 获取所有数据，并执行用户指定操作，函数返回 false 时终止
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.FoldContext(context.Background(), fn)
+}
+
+// FoldContext 同 Fold，ctx 结束时终止遍历并返回 ctx 的错误
+func (db *DB) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.fold(ctx, db.index, fn)
+}
 
-	iterator := db.index.Iterator(false)
+// Stat 获取默认 keyspace 的统计信息
+func (db *DB) Stat() *Stat {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	reclaimable, _ := db.reclaimableStat()
+	return &Stat{
+		KeyNum:          db.index.Size(),
+		ReclaimableSize: reclaimable,
+		IndexMemory:     db.index.MemoryUsage() + db.historyMemory + db.secondaryIndexMemory(),
+	}
+}
+
+// bucketIndex 获取 bucket 对应 keyspace 的内存索引，bucket 为空表示默认 keyspace
+// 不存在时，create 为 true 则创建，否则返回 nil，创建时调用方必须持有 db.mu 写锁
+func (db *DB) bucketIndex(bucket []byte, create bool) index.Indexer {
+	if len(bucket) == 0 {
+		return db.index
+	}
+	idx, ok := db.buckets[string(bucket)]
+	if !ok && create {
+		// 磁盘索引只用于默认 keyspace
+		typ := db.options.IndexType
+		if typ == BPTree {
+			typ = BTree
+		}
+		idx = db.newIndexer(typ)
+		db.buckets[string(bucket)] = idx
+	}
+	return idx
+}
+
+// newIndexer 创建内存索引，配置了多个分片时使用分片索引
+func (db *DB) newIndexer(typ IndexType) index.Indexer {
+	if db.options.IndexShards > 1 {
+		return index.NewShardedIndex(typ, db.options.IndexShards)
+	}
+	return index.NewIndexer(typ)
+}
+
+// checkKeySize 写入之前检查 key 是否可以存储到索引中，磁盘 B+ 树索引限制了默认 keyspace 中 key 的长度
+// 必须在追加到数据文件之前检查，否则重启时无法加载这条数据
+func (db *DB) checkKeySize(bucket []byte, key []byte) error {
+	if db.options.IndexType == BPTree && len(bucket) == 0 && len(key) > index.BPTreeMaxKeyLen {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// checkIndexMemory 写入一组数据之前检查所有 keyspace 的索引占用的内存是否达到上限，调用时必须持有 db.mu
+// 每条数据检查时累加同一组中之前的数据写入之后增加的内存，写入新的 key 时达到上限返回 ErrIndexMemoryExceeded
+func (db *DB) checkIndexMemory(records ...*data.LogRecord) error {
+	if db.options.MaxIndexMemory <= 0 {
+		return nil
+	}
+	usage := db.indexMemory()
+	for i, record := range records {
+		if i > 0 {
+			usage += db.estimateWriteMemory(records[i-1])
+		}
+		if record.Type != data.LogRecordNormal || db.keyExists(record.Bucket, record.Key) {
+			continue
+		}
+		if usage >= db.options.MaxIndexMemory {
+			return ErrIndexMemoryExceeded
+		}
+	}
+	return nil
+}
+
+// indexMemory 所有 keyspace 的索引、历史版本和二级索引占用的内存，调用时必须持有 db.mu
+func (db *DB) indexMemory() int64 {
+	usage := db.index.MemoryUsage() + db.historyMemory + db.secondaryIndexMemory()
+	for _, idx := range db.buckets {
+		usage += idx.MemoryUsage()
+	}
+	return usage
+}
+
+// estimateWriteMemory 估计写入一条数据之后索引、历史版本和二级索引增加的内存，删除时不计算减少的内存，调用时必须持有 db.mu
+func (db *DB) estimateWriteMemory(record *data.LogRecord) int64 {
+	memory := db.estimateHistoryMemory(record.Bucket, record.Key)
+	if record.Type != data.LogRecordNormal {
+		return memory
+	}
+	if !db.keyExists(record.Bucket, record.Key) {
+		// 命名 keyspace 不使用磁盘索引
+		typ := db.options.IndexType
+		if len(record.Bucket) > 0 && typ == BPTree {
+			typ = BTree
+		}
+		memory += index.EstimateKeyMemory(typ, record.Key)
+	}
+	if len(record.Bucket) == 0 {
+		for _, si := range db.secondary {
+			memory += si.estimatePut(record.Key, record.Value)
+		}
+	}
+	return memory
+}
+
+// keyExists keyspace 的索引中是否存在 key，调用时必须持有 db.mu
+func (db *DB) keyExists(bucket []byte, key []byte) bool {
+	idx := db.bucketIndex(bucket, false)
+	return idx != nil && idx.Get(key) != nil
+}
+
+// indexPut 更新 keyspace 索引中 key 的位置，被覆盖的数据计入可回收的空间，调用时必须持有 db.mu 写锁
+func (db *DB) indexPut(bucket []byte, key []byte, pos *data.LogRecordPos) bool {
+	idx := db.bucketIndex(bucket, true)
+	db.markReclaimable(bucket, idx.Get(key))
+	return idx.Put(key, pos)
+}
+
+// indexDelete 从 keyspace 索引中删除 key，被删除的数据计入可回收的空间，调用时必须持有 db.mu 写锁
+func (db *DB) indexDelete(bucket []byte, key []byte) bool {
+	idx := db.bucketIndex(bucket, false)
+	if idx == nil {
+		return false
+	}
+	oldPos := idx.Get(key)
+	if oldPos == nil {
+		return false
+	}
+	db.markReclaimable(bucket, oldPos)
+	if len(bucket) == 0 {
+		db.removeSecondaryIndexes(key)
+	}
+	return idx.Delete(key)
+}
+
+// markReclaimable 记录 keyspace 中 pos 位置的数据已经失效，调用时必须持有 db.mu 写锁
+func (db *DB) markReclaimable(bucket []byte, pos *data.LogRecordPos) {
+	if pos == nil {
+		return
+	}
+	db.reclaimable[pos.Fid] += int64(pos.Size)
+	if len(bucket) == 0 {
+		return
+	}
+	files, ok := db.bucketReclaimable[string(bucket)]
+	if !ok {
+		files = make(map[uint32]int64)
+		db.bucketReclaimable[string(bucket)] = files
+	}
+	files[pos.Fid] += int64(pos.Size)
+}
+
+// bucketReclaimableSize 统计命名 keyspace 中可以被 merge 回收的字节数，调用时必须持有 db.mu
+func (db *DB) bucketReclaimableSize(bucket []byte) int64 {
+	var reclaimable int64
+	for _, size := range db.bucketReclaimable[string(bucket)] {
+		reclaimable += size
+	}
+	return reclaimable
+}
+
+// reclaimableStat 统计可以被 merge 回收的字节数和参与统计的数据文件总大小，调用时必须持有 db.mu
+func (db *DB) reclaimableStat() (int64, int64) {
+	var reclaimable, total int64
+	for _, size := range db.reclaimable {
+		reclaimable += size
+	}
+	for _, file := range db.olderFiles {
+		total += file.WriteOff
+	}
+	if db.activeFile != nil {
+		total += db.activeFile.WriteOff
+	}
+	return reclaimable, total
+}
+
+// listKeys 获取索引中所有 key
+func listKeys(idx index.Indexer) [][]byte {
+	iterator := idx.Iterator(false)
+	keys := make([][]byte, idx.Size())
+	var i int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys[i] = iterator.Key()
+		i++
+	}
+	return keys
+}
+
+// fold 遍历索引中的所有数据，调用时必须持有 db.mu
+func (db *DB) fold(ctx context.Context, idx index.Indexer, fn func(key []byte, value []byte) bool) error {
+	iterator := idx.Iterator(false)
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -200,56 +573,67 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // getValueByPosition 根据索引信息获取对应的 value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	// 根据文件 id 找到对应的数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
+	logRecord, _, err := db.getLogRecordByPosition(logRecordPos)
+	if err != nil {
+		return nil, err
 	}
+	return logRecord.Value, nil
+}
+
+// getValueWithMeta 根据索引信息获取对应的 value 和元信息
+func (db *DB) getValueWithMeta(logRecordPos *data.LogRecordPos) ([]byte, *RecordMeta, error) {
+	logRecord, size, err := db.getLogRecordByPosition(logRecordPos)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := &RecordMeta{
+		Version:   logRecord.Version,
+		Timestamp: logRecord.Timestamp,
+		Size:      size,
+		Fid:       logRecordPos.Fid,
+	}
+	return logRecord.Value, meta, nil
+}
+
+// getLogRecordByPosition 根据索引信息获取对应的 LogRecord 和它的大小
+func (db *DB) getLogRecordByPosition(logRecordPos *data.LogRecordPos) (*data.LogRecord, int64, error) {
+	// 根据文件 id 找到对应的数据文件
+	dataFile := db.getDataFile(logRecordPos.Fid)
 	if dataFile == nil {
-		return nil, ErrDataFileNotFound
+		return nil, 0, ErrDataFileNotFound
 	}
 
 	// 根据偏移量读取对应数据
-	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	logRecord, size, err := dataFile.ReadLogRecord(logRecordPos.Offset)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrDataFileNotFound
+		return nil, 0, ErrDataFileNotFound
 	}
-	return logRecord.Value, nil
+	return logRecord, size, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// nextVersion 生成新的写入版本号和写入时间，调用时必须持有 db.mu
+func (db *DB) nextVersion() (uint64, int64) {
+	db.version++
+	return db.version, time.Now().UnixNano()
 }
 
-// appendLogRecord 将数据追加写入活跃数据文件
-func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	if db.activeFile == nil {
-		if err := db.setActiveDataFile(); err != nil {
-			return nil, err
-		}
+// getDataFile 根据文件 id 找到对应的数据文件，不存在时返回 nil
+func (db *DB) getDataFile(fileId uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fileId {
+		return db.activeFile
 	}
+	return db.olderFiles[fileId]
+}
 
+// appendLogRecord 将数据追加写入活跃数据文件
+func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 	encRecord, size := data.EncodeLogRecord(logRecord)
-	// 文件大小超过阈值后需要创建新的文件
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		// 保证已有数据写入磁盘
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		if err := db.setActiveDataFile(); err != nil {
-			return nil, err
-		}
+	if err := db.prepareActiveFile(size); err != nil {
+		return nil, err
 	}
 
 	writeOff := db.activeFile.WriteOff
@@ -263,25 +647,66 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	// 记录 key 的写入，用于事务冲突检测
+	if logRecord.Type == data.LogRecordNormal || logRecord.Type == data.LogRecordDeleted {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.txnTracker.markWritten(logRecord.Bucket, realKey)
+	}
+
 	pos := &data.LogRecordPos{
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
+		Size:   uint32(size),
 	}
 	return pos, nil
 }
 
-// setActiveDataFile 设置活跃文件，使用时必须有Mutex
-func (db *DB) setActiveDataFile() error {
-	var initialFileId uint32 = 0
-	if db.activeFile != nil {
-		initialFileId = db.activeFile.FileId + 1
+// prepareActiveFile 保证活跃文件可以写入 size 大小的数据，文件大小超过阈值后切换到新的文件
+func (db *DB) prepareActiveFile(size int64) error {
+	if db.activeFile == nil {
+		if err := db.setActiveDataFile(); err != nil {
+			return err
+		}
 	}
 
+	// 文件大小超过阈值后需要创建新的文件
+	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+		// 保证已有数据写入磁盘
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+
+		db.olderFiles[db.activeFile.FileId] = db.activeFile
+
+		if err := db.setActiveDataFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setActiveDataFile 设置活跃文件，使用时必须有Mutex
+func (db *DB) setActiveDataFile() error {
 	// 打开新的文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId)
+	fileId := db.allocFileId()
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId)
 	if err != nil {
 		return err
 	}
+
+	// 原来的活跃文件变成旧的数据文件，写入 manifest 之后新的文件才生效
+	state := db.manifest.state.clone()
+	if db.activeFile != nil {
+		state.dataFileIds = append(state.dataFileIds, db.activeFile.FileId)
+	}
+	state.activeFileId = fileId
+	state.seqNo = db.seqNo
+	state.version = db.version
+	if err := db.manifest.commit(state); err != nil {
+		_ = dataFile.Close()
+		_ = os.Remove(data.GetDataFileName(db.options.DirPath, fileId))
+		return err
+	}
 	db.activeFile = dataFile
 	return nil
 }
@@ -293,129 +718,155 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must be positive")
 	}
+	if options.WatchBufferSize <= 0 {
+		return errors.New("database watch buffer size must be positive")
+	}
+	if options.IndexLoadWorkers <= 0 {
+		return errors.New("database index load workers must be positive")
+	}
+	if options.IndexShards <= 0 {
+		return errors.New("database index shards must be positive")
+	}
+	if options.AutoMergeRatio < 0 || options.AutoMergeRatio > 1 {
+		return errors.New("invalid auto merge ratio, must between 0 and 1")
+	}
+	if options.AutoMergeRatio > 0 && options.AutoMergeInterval <= 0 {
+		return errors.New("auto merge interval must be positive")
+	}
+	if options.BackgroundBytesPerSec < 0 {
+		return errors.New("background bytes per second can not be negative")
+	}
+	if options.IndexType == BPTree && options.BPTreeCacheSize <= 0 {
+		return errors.New("bptree cache size must be positive")
+	}
+	for name, extractor := range options.SecondaryIndexes {
+		if name == "" || extractor == nil {
+			return errors.New("secondary index name and extractor can not be empty")
+		}
+	}
+	if options.MaxIndexMemory < 0 {
+		return errors.New("max index memory can not be negative")
+	}
+	if options.IndexCheckpointInterval < 0 {
+		return errors.New("index checkpoint interval can not be negative")
+	}
 	return nil
 }
 
 // loadDataFiles 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
-	if err != nil {
-		return err
+	state := db.manifest.state
+	// 还没有数据文件
+	if state.nextFileId == 0 {
+		return nil
 	}
 
-	var fileIds []int
-	// 遍历目录中所有文件，找到所有 .data 后缀的文件
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
-			splitNames := strings.Split(entry.Name(), ".")
-			fileId, err := strconv.Atoi(splitNames[0])
-			// 数据目录可能被损坏
+	// 打开 manifest 中记录的所有文件，文件不存在说明数据目录被损坏
+	openFile := func(fileId uint32) (*data.DataFile, error) {
+		if _, err := os.Stat(data.GetDataFileName(db.options.DirPath, fileId)); err != nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		return data.OpenDataFile(db.options.DirPath, fileId)
+	}
+	for _, fileIds := range [][]uint32{state.mergedFileIds, state.dataFileIds} {
+		for _, fileId := range fileIds {
+			dataFile, err := openFile(fileId)
 			if err != nil {
-				return ErrDataDirectoryCorrupted
+				return err
 			}
-			fileIds = append(fileIds, fileId)
+			db.olderFiles[fileId] = dataFile
 		}
 	}
-
-	// 对文件 id 排序，依次加载
-	sort.Ints(fileIds)
-	db.fileIds = fileIds
-
-	// 遍历文件 id，打开对应的文件
-	for i, fileId := range fileIds {
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fileId))
-		if err != nil {
-			return err
-		}
-
-		// 最后一个是活跃文件
-		if i == len(fileIds)-1 {
-			db.activeFile = dataFile
-		} else {
-			db.olderFiles[uint32(fileId)] = dataFile
-		}
+	dataFile, err := openFile(state.activeFileId)
+	if err != nil {
+		return err
 	}
+	db.activeFile = dataFile
 	return nil
 }
 
 // loadIndexFromDataFiles 从数据文件中加载索引
-// 遍历文件中的所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+// 遍历文件中的所有记录，并更新到内存索引中，from 不为空时从 from 的位置开始重放
+func (db *DB) loadIndexFromDataFiles(ctx context.Context, from *data.LogRecordPos) error {
 	// 没有文件，数据库为空，直接返回
-	if len(db.fileIds) == 0 {
+	if db.activeFile == nil {
 		return nil
 	}
 
-	// 查看是否执行过 merge
-	hasMerge, nonMergeFileId := false, uint32(0)
-	mergeFinishFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinishFileName); err == nil {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
-		if err != nil {
-			return err
-		}
-		hasMerge = true
-		nonMergeFileId = fid
-	}
-
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var ok bool
-		if typ == data.LogRecordDeleted {
-			ok = db.index.Delete(key)
-		} else {
-			ok = db.index.Put(key, pos)
+	updateIndex := func(logRecord *data.LogRecord, key []byte, pos *data.LogRecordPos) {
+		deleted := logRecord.Type == data.LogRecordDeleted
+		db.addHistory(logRecord.Bucket, key, logRecord.Version, logRecord.Timestamp, pos, deleted)
+		// 删除的 key 可能已经被范围删除或者 keyspace 删除移除，不需要校验结果
+		if deleted {
+			db.indexDelete(logRecord.Bucket, key)
+			db.markReclaimable(logRecord.Bucket, pos)
+			return
 		}
-		if !ok {
+		if ok := db.indexPut(logRecord.Bucket, key, pos); !ok {
 			panic("failed to update index at startup")
 		}
 	}
 
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
-
-	// 遍历所有文件 id，处理文件中的记录
-	for i, fid := range db.fileIds {
-		var fileId = uint32(fid)
-		// 比最近未参与 merge 的文件 id 更小，说明已经从 hint 文件中加载过索引
-		if hasMerge && fileId < nonMergeFileId {
-			continue
-		}
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.olderFiles[fileId]
-		}
-
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
+	var currentSeqNo = max(db.manifest.state.seqNo, db.seqNo)
+
+	// 按写入顺序处理需要扫描的文件，merge 生成的文件已经从 hint 文件中加载过索引
+	var files []*data.DataFile
+	for _, fileId := range db.manifest.state.dataFileIds {
+		files = append(files, db.olderFiles[fileId])
+	}
+	files = append(files, db.activeFile)
+	var startOffset int64
+	if from != nil {
+		for i, file := range files {
+			if file.FileId == from.Fid {
+				files = files[i:]
+				break
 			}
+		}
+		startOffset = from.Offset
+	}
+	progress := RecoveryProgress{Phase: RecoveryPhaseDataScan, FilesTotal: len(files)}
+	for _, file := range files {
+		progress.BytesTotal += file.WriteOff
+	}
+	progress.BytesTotal -= startOffset
 
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-			}
+	// 并发读取文件，按文件顺序更新索引，保证后写入的数据生效
+	scanner := newFileScanner(ctx, files, startOffset, db.options.IndexLoadWorkers)
+	defer scanner.stop()
+	for i := range files {
+		result := scanner.next(i)
+		if result.err != nil {
+			return result.err
+		}
 
-			// 解析 key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		for _, record := range result.records {
+			logRecord, logRecordPos, seqNo := record.logRecord, record.pos, record.seqNo
 			if seqNo == nonTransactionSeqNo {
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				switch logRecord.Type {
+				case data.LogRecordRangeDeleted:
+					keys := db.deleteIndexRange(logRecord.Bucket, logRecord.Key, logRecord.Value)
+					for _, key := range keys {
+						db.addHistory(logRecord.Bucket, key, logRecord.Version, logRecord.Timestamp, logRecordPos, true)
+					}
+					db.markReclaimable(logRecord.Bucket, logRecordPos)
+				case data.LogRecordBucketDropped:
+					db.dropBucketIndex(logRecord.Bucket)
+					db.markReclaimable(logRecord.Bucket, logRecordPos)
+				default:
+					updateIndex(logRecord, logRecord.Key, logRecordPos)
+				}
 			} else {
 				// 事务完成，对应的 sql no 的数据可以更新到内存索引中
 				if logRecord.Type == data.LogRecordFinished {
 					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+						updateIndex(txnRecord.Record, txnRecord.Record.Key, txnRecord.Pos)
 					}
 					delete(transactionRecords, seqNo)
+					db.markReclaimable(nil, logRecordPos)
 				} else {
-					logRecord.Key = realKey
 					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
 						Record: logRecord,
 						Pos:    logRecordPos,
@@ -423,19 +874,41 @@ func (db *DB) loadIndexFromDataFiles() error {
 				}
 			}
 
-			// 更新事务序列号
+			// 更新事务序列号和写入版本号
 			if seqNo > currentSeqNo {
 				currentSeqNo = seqNo
 			}
+			if logRecord.Version > db.version {
+				db.version = logRecord.Version
+			}
+		}
+		scanner.release()
 
-			// 递增 offset，下一次从新的位置开始读
-			offset += size
+		bytesRead := result.size
+		if i == 0 {
+			bytesRead -= startOffset
 		}
+		progress.FilesDone++
+		progress.BytesRead += bytesRead
+		progress.RecordsRead += int64(len(result.records))
+		db.recovery.FilesScanned++
+		db.recovery.BytesRead += bytesRead
+		db.recovery.RecordsRead += int64(len(result.records))
+		db.reportRecoveryProgress(progress)
 
 		// 如果是活跃文件，更新文件的 WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		if i == len(files)-1 {
+			db.activeFile.WriteOff = result.size
+		}
+	}
+
+	// 没有完成的事务数据不会生效，可以被回收
+	for _, txnRecords := range transactionRecords {
+		for _, txnRecord := range txnRecords {
+			db.markReclaimable(txnRecord.Record.Bucket, txnRecord.Pos)
 		}
+		db.recovery.DiscardedTxns++
+		db.recovery.DiscardedTxnRecords += len(txnRecords)
 	}
 
 	// 更新事务序列号
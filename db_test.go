@@ -1,8 +1,11 @@
 package bitcask_go
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -299,3 +302,395 @@ func TestDB_Sync(t *testing.T) {
 	err = db.Sync()
 	assert.Nil(t, err)
 }
+
+func TestDB_FoldContext(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fold-context")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(20))
+		assert.Nil(t, err)
+	}
+
+	// 遍历过程中取消
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err = db.FoldContext(ctx, func(key []byte, value []byte) bool {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestOpenContext(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-open-context")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(20))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.加载索引时取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db2, err := OpenContext(ctx, opt)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, db2)
+
+	// 2.正常打开
+	db3, err := OpenContext(context.Background(), opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db3.ListKeys()))
+}
+
+func TestDB_GetWithMeta(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-get-with-meta")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	_, _, err = db.GetWithMeta(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.每次写入版本号递增
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	val, meta1, err := db.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.True(t, meta1.Version > 0)
+	assert.True(t, meta1.Timestamp > 0)
+	assert.True(t, meta1.Size > 0)
+
+	err = db.Put(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	_, meta2, err := db.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, meta2.Version > meta1.Version)
+
+	// 3.WriteBatch 中的数据使用相同的版本号
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(2), []byte("c"))
+	assert.Nil(t, err)
+	err = wb.Put(utils.GetTestKey(3), []byte("d"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	_, meta3, err := db.GetWithMeta(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, meta4, err := db.GetWithMeta(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, meta3.Version, meta4.Version)
+	assert.True(t, meta3.Version > meta2.Version)
+
+	// 4.迭代器中获取元信息
+	iter := db.NewIterator(DefaultIteratorOptions)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, meta, err := iter.ValueWithMeta()
+		assert.Nil(t, err)
+		assert.True(t, meta.Version >= meta2.Version)
+	}
+
+	// 5.重启和 merge 之后版本号保持不变，并且继续递增
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	_, meta5, err := db2.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, meta2.Version, meta5.Version)
+	assert.Equal(t, meta2.Timestamp, meta5.Timestamp)
+	err = db2.Put(utils.GetTestKey(4), []byte("e"))
+	assert.Nil(t, err)
+	_, meta6, err := db2.GetWithMeta(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.True(t, meta6.Version > meta3.Version)
+}
+
+func TestDB_Version_AfterMerge(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-version-merge")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.最新版本是删除记录，merge 之后被丢弃
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("a")))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("b")))
+	_, meta, err := db.GetWithMeta(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete(utils.GetTestKey(2)))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 2.重启之后版本号不会重复使用
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(3), []byte("c")))
+	_, meta2, err := db.GetWithMeta(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, meta2.Version > meta.Version+1)
+}
+
+func TestOpen_ARTIndex(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-art")
+	opt.DirPath = dir
+	opt.IndexType = ART
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.写入和删除数据
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 2.重启之后从数据文件重建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 50, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i*2+1), key)
+	}
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestOpen_SkipListIndex(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-skiplist")
+	opt.DirPath = dir
+	opt.IndexType = SkipList
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.写入和删除数据
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 2.重启之后从数据文件重建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 50, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i*2+1), key)
+	}
+	iter := db.NewIterator(IteratorOptions{Reverse: true})
+	defer iter.Close()
+	iter.Seek(utils.GetTestKey(50))
+	assert.Equal(t, utils.GetTestKey(49), iter.Key())
+}
+
+func TestOpen_HashIndex(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hash")
+	opt.DirPath = dir
+	opt.IndexType = Hash
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.写入和删除数据
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i += 2 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 2.重启之后从数据文件重建索引，遍历时按照 key 排序
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	keys := db.ListKeys()
+	assert.Equal(t, 50, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i*2+1), key)
+	}
+}
+
+func TestOpen_ShardedIndex(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded")
+	opt.DirPath = dir
+	opt.IndexShards = 8
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.并发写入
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 100; i < (g+1)*100; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 2.有序遍历
+	keys := db.ListKeys()
+	assert.Equal(t, 400, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i), key)
+	}
+	iter := db.NewIterator(IteratorOptions{Reverse: true})
+	iter.Seek(utils.GetTestKey(200))
+	assert.Equal(t, utils.GetTestKey(200), iter.Key())
+	iter.Next()
+	assert.Equal(t, utils.GetTestKey(199), iter.Key())
+	iter.Close()
+
+	// 3.重启之后重建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, db.Stat().KeyNum)
+
+	// 4.分片数量必须为正数
+	opt.IndexShards = 0
+	_, err = Open(opt)
+	assert.NotNil(t, err)
+}
+
+func TestDB_MaxIndexMemory(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-memory")
+	opt.DirPath = dir
+	opt.MaxIndexMemory = 64 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 1.写入新的 key 直到达到上限
+	var n int
+	for ; n < 100000; n++ {
+		err = db.Put(utils.GetTestKey(n), utils.RandomValue(8))
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrIndexMemoryExceeded, err)
+	assert.True(t, n > 0)
+	stat := db.Stat()
+	assert.Equal(t, n, stat.KeyNum)
+	assert.True(t, stat.IndexMemory >= opt.MaxIndexMemory)
+
+	// 2.覆盖和删除已有的 key 不受影响
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("new")))
+	ok, err := db.PutIfAbsent(utils.GetTestKey(n), []byte("new"))
+	assert.False(t, ok)
+	assert.Equal(t, ErrIndexMemoryExceeded, err)
+	bucket, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrIndexMemoryExceeded, bucket.Put([]byte("name"), []byte("bitcask")))
+
+	// 3.批量写入中有新的 key 时整个批次都不会写入
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("batch")))
+	assert.Nil(t, wb.Put(utils.GetTestKey(n), []byte("batch")))
+	assert.Equal(t, ErrIndexMemoryExceeded, wb.Commit())
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("batch"), val)
+
+	// 4.删除之后可以继续写入新的 key
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(n), []byte("new")))
+}
+
+func TestDB_MaxIndexMemory_Batch(t *testing.T) {
+	opt := newSecondaryIndexOptions("bitcask-go-index-memory-batch")
+	opt.MaxIndexMemory = 64 * 1024
+	opt.HistoryVersions = 4
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 1.一个批次写入的数据累计超过上限时整个批次都不会写入
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("user|user-%d@example.com", i))))
+	}
+	assert.Equal(t, ErrIndexMemoryExceeded, wb.Commit())
+	assert.Equal(t, 0, db.Stat().KeyNum)
+	assert.Equal(t, int64(0), db.Stat().IndexMemory)
+
+	// 2.没有超过上限的批次可以写入，二级索引和历史版本计入占用的内存
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("user|user-%d@example.com", i))))
+	}
+	assert.Nil(t, wb.Commit())
+	stat := db.Stat()
+	assert.Equal(t, 100, stat.KeyNum)
+	assert.True(t, stat.IndexMemory > db.index.MemoryUsage()+db.historyMemory)
+	assert.True(t, stat.IndexMemory < opt.MaxIndexMemory)
+
+	// 3.删除之后二级索引占用的内存释放
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, int64(0), db.secondaryIndexMemory())
+}
@@ -0,0 +1,90 @@
+package bitcask_go
+
+import (
+	"bytes"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，end 为空表示删除 start 之后的所有 key
+// 只写入一条范围删除标记，不会为每个 key 写入删除记录
+func (db *DB) DeleteRange(start []byte, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.deleteRange(start, end)
+}
+
+// DeletePrefix 删除所有以 prefix 为前缀的 key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.deleteRange(prefix, prefixEnd(prefix))
+}
+
+// deleteRange 写入范围删除标记并更新内存索引，调用时必须持有 db.mu
+func (db *DB) deleteRange(start []byte, end []byte) error {
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, nonTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	}
+	logRecord.Version, logRecord.Timestamp = db.nextVersion()
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	keys := db.deleteIndexRange(nil, start, end)
+	db.markReclaimable(nil, pos)
+	changes := make([]*data.LogRecord, 0, len(keys))
+	for _, key := range keys {
+		db.txnTracker.markWritten(nil, key)
+		db.addHistory(nil, key, logRecord.Version, logRecord.Timestamp, pos, true)
+		changes = append(changes, &data.LogRecord{Key: key, Type: data.LogRecordDeleted, Version: logRecord.Version})
+	}
+
+	db.watchHub.notify(changes)
+	return nil
+}
+
+// deleteIndexRange 从 keyspace 索引中删除 [start, end) 范围内的 key，返回被删除的 key
+func (db *DB) deleteIndexRange(bucket []byte, start []byte, end []byte) [][]byte {
+	idx := db.bucketIndex(bucket, false)
+	if idx == nil {
+		return nil
+	}
+	var keys [][]byte
+	iterator := idx.Iterator(false)
+	defer iterator.Close()
+	for iterator.Seek(start); iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		db.indexDelete(bucket, key)
+	}
+	return keys
+}
+
+// prefixEnd 返回所有以 prefix 为前缀的 key 的上界，prefix 全部为 0xff 时返回 nil，表示没有上界
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
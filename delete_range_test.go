@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	// 1.范围不合法
+	err = db.DeleteRange(utils.GetTestKey(20), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidRange, err)
+
+	// 2.删除一个范围
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(19))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+
+	// 3.删除之后重新写入
+	err = db.Put(utils.GetTestKey(15), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	// 4.没有终点
+	err = db.DeleteRange(utils.GetTestKey(90), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db.ListKeys()))
+
+	// 5.重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(12))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(95))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-prefix")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	keys := []string{"tenant-a/1", "tenant-a/2", "tenant-ab/1", "tenant-b/1", "tenant-"}
+	for _, key := range keys {
+		err := db.Put([]byte(key), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	err = db.DeletePrefix([]byte("tenant-a/"))
+	assert.Nil(t, err)
+	remaining := func(db *DB) []string {
+		var res []string
+		for _, key := range db.ListKeys() {
+			res = append(res, string(key))
+		}
+		return res
+	}
+	assert.Equal(t, []string{"tenant-", "tenant-ab/1", "tenant-b/1"}, remaining(db))
+
+	// 重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tenant-", "tenant-ab/1", "tenant-b/1"}, remaining(db2))
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte{'a', 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
@@ -0,0 +1,255 @@
+package bitcask_go
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/index"
+)
+
+// indexCheckpoint 持久化磁盘索引时记录的数据库状态，重启时从 pos 开始重放数据文件
+type indexCheckpoint struct {
+	pos         *data.LogRecordPos // 索引已经包含的数据的结束位置，为空表示还没有数据文件
+	hintFile    string             // 索引对应的 merge 结果，和 manifest 不同时说明新的 merge 已经生效，需要重建索引
+	seqNo       uint64
+	version     uint64
+	complete    bool // 存在命名 keyspace 或者保留历史版本时，这些内存中的状态需要重新扫描数据文件才能恢复
+	reclaimable map[uint32]int64
+}
+
+// openDiskIndex 打开磁盘 B+ 树索引，checkpoint 可以使用时恢复数据库状态，返回需要开始重放的位置
+func (db *DB) openDiskIndex() (*data.LogRecordPos, error) {
+	if db.options.IndexType != BPTree {
+		return nil, nil
+	}
+	db.loadedHintFile = db.manifest.state.hintFile
+
+	path := filepath.Join(db.options.DirPath, data.BPTreeIndexFileName)
+	tree, err := index.NewBPlusTree(path, db.options.BPTreeCacheSize)
+	if err == index.ErrIndexFileCorrupted {
+		// 索引文件损坏时可以从数据文件重建
+		if err = os.Remove(path); err == nil {
+			tree, err = index.NewBPlusTree(path, db.options.BPTreeCacheSize)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.diskIndex, db.index = tree, tree
+
+	cp, err := decodeIndexCheckpoint(tree.CheckpointMeta())
+	if err == nil && db.checkpointUsable(cp) {
+		db.seqNo, db.version, db.reclaimable = cp.seqNo, cp.version, cp.reclaimable
+		db.recovery.IndexCheckpointUsed = true
+		return cp.pos, nil
+	}
+	if tree.Size() == 0 {
+		return nil, nil
+	}
+
+	// 索引和数据文件不一致，删除之后从头重建
+	if err := tree.Close(); err != nil {
+		return nil, err
+	}
+	db.diskIndex, db.index = nil, nil
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	if tree, err = index.NewBPlusTree(path, db.options.BPTreeCacheSize); err != nil {
+		return nil, err
+	}
+	db.diskIndex, db.index = tree, tree
+	return nil, nil
+}
+
+// checkpointUsable 判断 checkpoint 是否和当前的数据文件一致
+func (db *DB) checkpointUsable(cp *indexCheckpoint) bool {
+	if !cp.complete || db.historyEnabled() || cp.hintFile != db.manifest.state.hintFile {
+		return false
+	}
+	if cp.pos == nil {
+		return true
+	}
+	// checkpoint 所在的文件必须还需要扫描，并且数据没有丢失
+	if cp.pos.Fid != db.manifest.state.activeFileId {
+		found := false
+		for _, fid := range db.manifest.state.dataFileIds {
+			found = found || fid == cp.pos.Fid
+		}
+		if !found {
+			return false
+		}
+	}
+	dataFile := db.getDataFile(cp.pos.Fid)
+	return dataFile != nil && cp.pos.Offset <= dataFile.WriteOff
+}
+
+// checkpointIndex 持久化磁盘索引，调用时必须持有 db.mu 写锁，保证没有写入到一半的批次
+func (db *DB) checkpointIndex() error {
+	if db.diskIndex == nil || !db.indexLoaded {
+		return nil
+	}
+	cp := &indexCheckpoint{
+		hintFile:    db.loadedHintFile,
+		seqNo:       db.seqNo,
+		version:     db.version,
+		complete:    len(db.buckets) == 0 && !db.historyEnabled(),
+		reclaimable: db.reclaimable,
+	}
+	if db.activeFile != nil {
+		// 索引引用的数据必须先持久化
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+		cp.pos = &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff}
+	}
+	return db.diskIndex.Checkpoint(encodeIndexCheckpoint(cp))
+}
+
+// closeDiskIndex 持久化并关闭磁盘索引，调用时必须持有 db.mu 写锁
+func (db *DB) closeDiskIndex() error {
+	if db.diskIndex == nil {
+		return nil
+	}
+	if err := db.checkpointIndex(); err != nil {
+		return err
+	}
+	tree := db.diskIndex
+	db.diskIndex = nil
+	return tree.Close()
+}
+
+// startIndexCheckpoint 根据配置启动后台定期持久化磁盘索引
+func (db *DB) startIndexCheckpoint() {
+	if db.diskIndex == nil || db.options.IndexCheckpointInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	db.stopCheckpoint = cancel
+	db.checkpointDone = make(chan struct{})
+	go func() {
+		defer close(db.checkpointDone)
+
+		ticker := time.NewTicker(db.options.IndexCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// 持久化失败时索引不受影响，重启时重放更多的数据
+				db.mu.Lock()
+				_ = db.checkpointIndex()
+				db.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopIndexCheckpoint 停止后台持久化磁盘索引，等待后台 goroutine 退出之后返回
+func (db *DB) stopIndexCheckpoint() {
+	if db.stopCheckpoint == nil {
+		return
+	}
+	db.stopCheckpoint()
+	<-db.checkpointDone
+	db.stopCheckpoint = nil
+}
+
+func encodeIndexCheckpoint(cp *indexCheckpoint) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64*(8+2*len(cp.reclaimable))+len(cp.hintFile))
+	if cp.pos != nil {
+		buf = append(buf, 1)
+		buf = binary.AppendUvarint(buf, uint64(cp.pos.Fid))
+		buf = binary.AppendUvarint(buf, uint64(cp.pos.Offset))
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(cp.hintFile)))
+	buf = append(buf, cp.hintFile...)
+	buf = binary.AppendUvarint(buf, cp.seqNo)
+	buf = binary.AppendUvarint(buf, cp.version)
+	if cp.complete {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(cp.reclaimable)))
+	for fid, size := range cp.reclaimable {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+		buf = binary.AppendUvarint(buf, uint64(size))
+	}
+	return buf
+}
+
+func decodeIndexCheckpoint(buf []byte) (*indexCheckpoint, error) {
+	cp := &indexCheckpoint{reclaimable: make(map[uint32]int64)}
+	index := 0
+	next := func() (uint64, error) {
+		if index >= len(buf) {
+			return 0, ErrDataDirectoryCorrupted
+		}
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return 0, ErrDataDirectoryCorrupted
+		}
+		index += n
+		return v, nil
+	}
+
+	hasPos, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if hasPos == 1 {
+		fid, err := next()
+		if err != nil {
+			return nil, err
+		}
+		offset, err := next()
+		if err != nil {
+			return nil, err
+		}
+		cp.pos = &data.LogRecordPos{Fid: uint32(fid), Offset: int64(offset)}
+	}
+	hintLen, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(buf)-index) < hintLen {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	cp.hintFile = string(buf[index : index+int(hintLen)])
+	index += int(hintLen)
+	if cp.seqNo, err = next(); err != nil {
+		return nil, err
+	}
+	if cp.version, err = next(); err != nil {
+		return nil, err
+	}
+	complete, err := next()
+	if err != nil {
+		return nil, err
+	}
+	cp.complete = complete == 1
+	num, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < num; i++ {
+		fid, err := next()
+		if err != nil {
+			return nil, err
+		}
+		size, err := next()
+		if err != nil {
+			return nil, err
+		}
+		cp.reclaimable[uint32(fid)] = int64(size)
+	}
+	return cp, nil
+}
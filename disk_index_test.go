@@ -0,0 +1,201 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/index"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func newDiskIndexOptions(pattern string) Options {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", pattern)
+	opt.DirPath = dir
+	opt.DataFileSize = 64 * 1024
+	opt.IndexType = BPTree
+	opt.BPTreeCacheSize = 16 * 4096
+	opt.IndexCheckpointInterval = 0
+	return opt
+}
+
+// crashDB 不持久化磁盘索引直接关闭数据库，模拟进程崩溃
+func crashDB(t *testing.T, db *DB) {
+	assert.Nil(t, db.diskIndex.Close())
+	db.diskIndex = nil
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_DiskIndex(t *testing.T) {
+	opt := newDiskIndexOptions("bitcask-go-disk-index-1")
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.写入和删除数据
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 3000; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	stat := db.Stat()
+	assert.Nil(t, db.Close())
+
+	// 2.正常关闭之后重新打开，不需要扫描数据
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.True(t, report.IndexCheckpointUsed)
+	assert.Equal(t, int64(0), report.RecordsRead)
+	assert.Equal(t, stat.KeyNum, db.Stat().KeyNum)
+	assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+	keys := db.ListKeys()
+	assert.Equal(t, 2000, len(keys))
+	for i := 0; i < 3000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i%3 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+
+	// 3.checkpoint 之后继续写入，崩溃之后从 checkpoint 重放之后写入的数据
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("batch")))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	crashDB(t, db)
+
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	report = db.RecoveryReport()
+	assert.True(t, report.IndexCheckpointUsed)
+	assert.Equal(t, int64(100+1+1), report.RecordsRead)
+	assert.Equal(t, 2000+34-1, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DiskIndex_Rebuild(t *testing.T) {
+	opt := newDiskIndexOptions("bitcask-go-disk-index-2")
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 1.merge 生效时直接更新磁盘索引中的位置，重启之后不需要加载 hint 文件
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.True(t, db.RecoveryReport().IndexCheckpointUsed)
+	assert.Equal(t, 0, db.RecoveryReport().HintFilesUsed)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+
+	// 2.索引文件删除之后从 hint 文件重建，再次打开可以使用 checkpoint
+	assert.Nil(t, os.Remove(filepath.Join(opt.DirPath, data.BPTreeIndexFileName)))
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.False(t, db.RecoveryReport().IndexCheckpointUsed)
+	assert.Equal(t, 1, db.RecoveryReport().HintFilesUsed)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.True(t, db.RecoveryReport().IndexCheckpointUsed)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+
+	// 3.索引文件损坏时重建
+	err = os.WriteFile(filepath.Join(opt.DirPath, data.BPTreeIndexFileName), []byte("corrupted"), 0644)
+	assert.Nil(t, err)
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.False(t, db.RecoveryReport().IndexCheckpointUsed)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for i := 1000; i < 2000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 4.存在命名 keyspace 时需要重新扫描数据文件
+	bucket, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, bucket.Put([]byte("name"), []byte("bitcask")))
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.False(t, db.RecoveryReport().IndexCheckpointUsed)
+	bucket, err = db.Bucket("users")
+	assert.Nil(t, err)
+	val, err := bucket.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), val)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+func TestDB_DiskIndex_KeyTooLarge(t *testing.T) {
+	opt := newDiskIndexOptions("bitcask-go-disk-index-3")
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	largeKey := bytes.Repeat([]byte("k"), index.BPTreeMaxKeyLen+1)
+	maxKey := largeKey[:index.BPTreeMaxKeyLen]
+
+	// 1.超过长度的 key 在追加到数据文件之前返回错误
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask")))
+	writeOff := db.activeFile.WriteOff
+	assert.Equal(t, ErrKeyTooLarge, db.Put(largeKey, []byte("value")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("small"), []byte("value")))
+	assert.Nil(t, wb.Put(largeKey, []byte("value")))
+	assert.Equal(t, ErrKeyTooLarge, wb.Commit())
+	assert.Equal(t, ErrKeyTooLarge, db.PutReader(largeKey, bytes.NewReader([]byte("value")), 5))
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+
+	// 2.最大长度的 key 和命名 keyspace 中的 key 不受影响
+	assert.Nil(t, db.Put(maxKey, []byte("value")))
+	bucket, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, bucket.Put(largeKey, []byte("value")))
+
+	// 3.重启之后可以正常加载
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	val, err := db.Get(maxKey)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	_, err = db.Get([]byte("small"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	bucket, err = db.Bucket("users")
+	assert.Nil(t, err)
+	val, err = bucket.Get(largeKey)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
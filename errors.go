@@ -10,4 +10,14 @@ var (
 	ErrDataDirectoryCorrupted = errors.New("database directory maybe corrupted")
 	ErrExceedMaxBatchNum      = errors.New("exceeded the max batch number")
 	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
+	ErrInvalidValueSize       = errors.New("value size must be between 0 and 2GB")
+	ErrBucketNameIsEmpty      = errors.New("bucket name is empty")
+	ErrInvalidRange           = errors.New("range start must be less than range end")
+	ErrHistoryNotEnabled      = errors.New("history is not enabled, set HistoryVersions or HistoryRetention")
+	ErrVersionNotFound        = errors.New("version is not retained in history")
+	ErrTxnConflict            = errors.New("transaction conflict, read keys were modified by others")
+	ErrTxnClosed              = errors.New("transaction has been committed or discarded")
+	ErrIndexMemoryExceeded    = errors.New("index memory limit exceeded, can not write new keys")
+	ErrIndexNotFound          = errors.New("secondary index is not registered, set it in SecondaryIndexes")
+	ErrKeyTooLarge            = errors.New("key is too large for the B+ tree index")
 )
@@ -34,6 +34,10 @@ func (fio *FileIO) Close() error {
 	return fio.fd.Close()
 }
 
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
+
 func (fio *FileIO) Size() (int64, error) {
 	stat, err := fio.fd.Stat()
 	if err != nil {
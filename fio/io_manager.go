@@ -17,6 +17,9 @@ type IOManager interface {
 
 	// Size 得到文件大小
 	Size() (int64, error)
+
+	// Truncate 将文件截断到 size 大小，之后的写入从 size 开始
+	Truncate(size int64) error
 }
 
 func NewIOManager(fileName string) (IOManager, error) {
@@ -0,0 +1,290 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// KeyVersion key 的一个历史版本
+type KeyVersion struct {
+	Version   uint64
+	Timestamp int64
+	Deleted   bool   // 该版本是否为删除
+	Value     []byte // 删除的版本为空
+}
+
+// versionPos 历史版本在数据文件中的位置
+type versionPos struct {
+	version   uint64
+	timestamp int64
+	pos       *data.LogRecordPos // 删除的版本为删除记录的位置
+	deleted   bool
+}
+
+// historyExpiry 按写入顺序记录每个历史版本，只设置了 HistoryRetention 时用于清理之后没有再写入的 key
+type historyExpiry struct {
+	key       string
+	timestamp int64
+}
+
+var (
+	// historyKeyMemory 每个保留历史版本的 key 占用的内存，不包括 key 本身
+	historyKeyMemory = int64(unsafe.Sizeof("") + unsafe.Sizeof([]*versionPos(nil)))
+	// historyVersionMemory 每个历史版本占用的内存
+	historyVersionMemory = int64(unsafe.Sizeof(versionPos{}) + unsafe.Sizeof(data.LogRecordPos{}) + unsafe.Sizeof(&versionPos{}))
+	historyExpiryMemory  = int64(unsafe.Sizeof(historyExpiry{}))
+)
+
+// History 获取默认 keyspace 中 key 保留的所有历史版本，按版本号从新到旧排列
+func (db *DB) History(key []byte) ([]*KeyVersion, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if !db.historyEnabled() {
+		return nil, ErrHistoryNotEnabled
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	versions := db.retainedHistory(db.history[string(key)])
+	if len(versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	result := make([]*KeyVersion, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		kv, err := db.readKeyVersion(versions[i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, kv)
+	}
+	return result, nil
+}
+
+// GetAt 读取默认 keyspace 中 key 在 version 时的数据，即版本号不大于 version 的最新版本
+// 该版本已经被清理时返回 ErrVersionNotFound，该版本为删除时返回 ErrKeyNotFound
+func (db *DB) GetAt(key []byte, version uint64) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if !db.historyEnabled() {
+		return nil, ErrHistoryNotEnabled
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	versions := db.retainedHistory(db.history[string(key)])
+	if len(versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	// 找到第一个版本号大于 version 的位置，前一个就是目标版本
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].version > version
+	})
+	if i == 0 {
+		return nil, ErrVersionNotFound
+	}
+	kv, err := db.readKeyVersion(versions[i-1])
+	if err != nil {
+		return nil, err
+	}
+	if kv.Deleted {
+		return nil, ErrKeyNotFound
+	}
+	return kv.Value, nil
+}
+
+func (db *DB) historyEnabled() bool {
+	return db.options.HistoryVersions > 0 || db.options.HistoryRetention > 0
+}
+
+// addHistory 记录 key 的一个新版本，只记录默认 keyspace，调用时必须持有 db.mu 写锁
+// 同时清理 key 和已经超过保留时间的其他 key 的历史版本，读取时不需要修改历史版本
+func (db *DB) addHistory(bucket []byte, key []byte, version uint64, timestamp int64, pos *data.LogRecordPos, deleted bool) {
+	if !db.historyEnabled() || len(bucket) > 0 {
+		return
+	}
+	k := string(key)
+	// 压缩过滤器重写的数据保留原来的版本号，加载数据文件时同一个版本号以最后写入的位置为准
+	if versions := db.history[k]; len(versions) > 0 && versions[len(versions)-1].version == version {
+		last := versions[len(versions)-1]
+		last.timestamp, last.pos, last.deleted = timestamp, pos, deleted
+		return
+	}
+	db.setHistory(k, append(db.history[k], &versionPos{
+		version:   version,
+		timestamp: timestamp,
+		pos:       pos,
+		deleted:   deleted,
+	}))
+	db.trimHistory(k)
+
+	if db.options.HistoryRetention > 0 {
+		db.historyExpiries = append(db.historyExpiries, historyExpiry{key: k, timestamp: timestamp})
+		db.historyMemory += historyExpiryMemory
+		db.trimExpiredHistory()
+	}
+}
+
+// estimateHistoryMemory 估计记录 key 的一个新版本之后增加的内存，不计算清理的版本，调用时必须持有 db.mu
+func (db *DB) estimateHistoryMemory(bucket []byte, key []byte) int64 {
+	if !db.historyEnabled() || len(bucket) > 0 {
+		return 0
+	}
+	memory := historyVersionMemory
+	if _, ok := db.history[string(key)]; !ok {
+		memory += historyKeyMemory + int64(len(key))
+	}
+	if db.options.HistoryRetention > 0 {
+		memory += historyExpiryMemory
+	}
+	return memory
+}
+
+// trimExpiredHistory 按写入顺序清理已经超过保留时间的历史版本，调用时必须持有 db.mu 写锁
+func (db *DB) trimExpiredHistory() {
+	expired := time.Now().Add(-db.options.HistoryRetention).UnixNano()
+	head := 0
+	for head < len(db.historyExpiries) && db.historyExpiries[head].timestamp < expired {
+		db.trimHistory(db.historyExpiries[head].key)
+		head++
+	}
+	if head == 0 {
+		return
+	}
+	db.historyMemory -= int64(head) * historyExpiryMemory
+	// 队列前面的空间不会被复用，超过一半时重新分配
+	if head*2 >= cap(db.historyExpiries) {
+		db.historyExpiries = append([]historyExpiry(nil), db.historyExpiries[head:]...)
+	} else {
+		db.historyExpiries = db.historyExpiries[head:]
+	}
+}
+
+// trimHistory 根据保留策略清理 key 的历史版本，调用时必须持有 db.mu 写锁
+func (db *DB) trimHistory(key string) {
+	versions := db.history[key]
+	if retained := db.retainedHistory(versions); len(retained) < len(versions) {
+		db.setHistory(key, append([]*versionPos(nil), retained...))
+	}
+}
+
+// retainedHistory 根据保留策略返回需要保留的历史版本，最新的版本总是保留，不修改 versions，调用时必须持有 db.mu
+func (db *DB) retainedHistory(versions []*versionPos) []*versionPos {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	start := 0
+	if maxVersions := db.options.HistoryVersions; maxVersions > 0 && len(versions) > maxVersions {
+		start = len(versions) - maxVersions
+	}
+	if retention := db.options.HistoryRetention; retention > 0 {
+		expired := time.Now().Add(-retention).UnixNano()
+		for start < len(versions)-1 && versions[start].timestamp < expired {
+			start++
+		}
+	}
+
+	// 只剩下一个删除的版本，不需要再保留
+	if start == len(versions)-1 && versions[start].deleted {
+		return nil
+	}
+	return versions[start:]
+}
+
+// setHistory 替换 key 保留的历史版本并更新内存统计，versions 为空时删除，调用时必须持有 db.mu 写锁
+func (db *DB) setHistory(key string, versions []*versionPos) {
+	if old, ok := db.history[key]; ok {
+		db.historyMemory -= historyKeyMemory + int64(len(key)) + int64(cap(old))*historyVersionMemory
+	}
+	if len(versions) == 0 {
+		delete(db.history, key)
+		return
+	}
+	db.history[key] = versions
+	db.historyMemory += historyKeyMemory + int64(len(key)) + int64(cap(versions))*historyVersionMemory
+}
+
+// isHistoryRetained 数据文件中的位置是否为 key 需要保留的历史版本，用于 merge，调用时必须持有 db.mu
+// 范围删除产生的删除版本位于范围删除标记上，由 rangeDeletedHistory 单独处理
+func (db *DB) isHistoryRetained(bucket []byte, key []byte, fid uint32, offset int64) bool {
+	if len(bucket) > 0 {
+		return false
+	}
+	for _, v := range db.retainedHistory(db.history[string(key)]) {
+		if v.pos != nil && v.pos.Fid == fid && v.pos.Offset == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeDeletedHistory 返回保留的历史版本中位于范围删除标记上的 key，按照 key 排序，用于 merge，调用时必须持有 db.mu
+// merge 不会保留范围删除标记，这些删除版本需要逐个 key 重写为删除记录，否则重启之后被删除的数据会重新出现
+func (db *DB) rangeDeletedHistory(fid uint32, offset int64) [][]byte {
+	var keys [][]byte
+	for key, versions := range db.history {
+		for _, v := range db.retainedHistory(versions) {
+			if v.deleted && v.pos != nil && v.pos.Fid == fid && v.pos.Offset == offset {
+				keys = append(keys, []byte(key))
+				break
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// replaceHistoryPos 数据被重写到新的位置之后更新对应历史版本的位置，调用时必须持有 db.mu
+func (db *DB) replaceHistoryPos(bucket []byte, key []byte, version uint64, pos *data.LogRecordPos) {
+	if len(bucket) > 0 {
+		return
+	}
+	for _, v := range db.history[string(key)] {
+		if v.version == version && !v.deleted {
+			v.pos = pos
+		}
+	}
+}
+
+// moveHistoryPos merge 生效时将位置为 oldPos 的历史版本更新到 newPos，返回是否存在这样的历史版本，调用时必须持有 db.mu 写锁
+func (db *DB) moveHistoryPos(bucket []byte, key []byte, oldPos *data.LogRecordPos, newPos *data.LogRecordPos) bool {
+	if len(bucket) > 0 {
+		return false
+	}
+	moved := false
+	for _, v := range db.history[string(key)] {
+		if v.pos != nil && v.pos.Fid == oldPos.Fid && v.pos.Offset == oldPos.Offset {
+			v.pos = newPos
+			moved = true
+		}
+	}
+	return moved
+}
+
+// readKeyVersion 读取历史版本对应的数据
+func (db *DB) readKeyVersion(v *versionPos) (*KeyVersion, error) {
+	kv := &KeyVersion{
+		Version:   v.version,
+		Timestamp: v.timestamp,
+		Deleted:   v.deleted,
+	}
+	if v.deleted {
+		return kv, nil
+	}
+	value, err := db.getValueByPosition(v.pos)
+	if err != nil {
+		return nil, err
+	}
+	kv.Value = value
+	return kv, nil
+}
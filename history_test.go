@@ -0,0 +1,255 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_History(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-1")
+	opt.DirPath = dir
+	opt.HistoryVersions = 3
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	var versions []uint64
+	for i := 0; i < 4; i++ {
+		err = db.Put(utils.GetTestKey(1), []byte{byte('a' + i)})
+		assert.Nil(t, err)
+		_, meta, err := db.GetWithMeta(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		versions = append(versions, meta.Version)
+	}
+
+	// 1.只保留最新的 3 个版本，按版本号从新到旧排列
+	history, err := db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, versions[3], history[0].Version)
+	assert.Equal(t, []byte("d"), history[0].Value)
+	assert.Equal(t, []byte("b"), history[2].Value)
+
+	// 2.读取指定版本的数据
+	val, err := db.GetAt(utils.GetTestKey(1), versions[2])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	val, err = db.GetAt(utils.GetTestKey(1), versions[3]+100)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+	_, err = db.GetAt(utils.GetTestKey(1), versions[0])
+	assert.Equal(t, ErrVersionNotFound, err)
+
+	// 3.删除之后仍然可以读到之前的版本
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	history, err = db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, history[0].Deleted)
+	_, err = db.GetAt(utils.GetTestKey(1), history[0].Version)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.GetAt(utils.GetTestKey(1), versions[3])
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+
+	// 4.不存在的 key
+	_, err = db.History(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_History_Disabled(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-2")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	_, err = db.History(utils.GetTestKey(1))
+	assert.Equal(t, ErrHistoryNotEnabled, err)
+	_, err = db.GetAt(utils.GetTestKey(1), 1)
+	assert.Equal(t, ErrHistoryNotEnabled, err)
+}
+
+func TestDB_History_Restart(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-3")
+	opt.DirPath = dir
+	opt.HistoryVersions = 2
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(2), []byte("a"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("b"))
+	assert.Nil(t, err)
+
+	// 1.merge 之后重启，历史版本仍然有效
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	for _, key := range [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)} {
+		history, err := db2.History(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(history))
+		assert.Equal(t, []byte("b"), history[0].Value)
+		assert.Equal(t, []byte("a"), history[1].Value)
+	}
+
+	// 2.重启之后新的写入版本号继续递增
+	err = db2.Put(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	history, err := db2.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, []byte("c"), history[0].Value)
+	assert.Equal(t, []byte("b"), history[1].Value)
+}
+
+func TestDB_History_Retention(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-4")
+	opt.DirPath = dir
+	opt.HistoryRetention = 50 * time.Millisecond
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3; i++ {
+		err = db.Put(utils.GetTestKey(1), []byte{byte('a' + i)})
+		assert.Nil(t, err)
+	}
+
+	// 1.历史版本计入索引内存
+	assert.True(t, db.historyMemory > 0)
+	assert.Equal(t, db.index.MemoryUsage()+db.historyMemory, db.Stat().IndexMemory)
+	history, err := db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+
+	// 2.超过保留时间之后读取不到，不会修改历史版本
+	time.Sleep(100 * time.Millisecond)
+	history, err = db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, 3, len(db.history[string(utils.GetTestKey(1))]))
+
+	// 3.写入其他 key 时清理没有再写入的 key
+	memory := db.historyMemory
+	err = db.Put(utils.GetTestKey(2), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.history[string(utils.GetTestKey(1))]))
+	assert.Equal(t, 1, len(db.historyExpiries))
+	assert.True(t, db.historyMemory < memory)
+}
+
+func TestDB_History_MergeOrder(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-5")
+	opt.DirPath = dir
+	opt.HistoryVersions = 3
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.merge 生成的文件 id 大于之后写入的文件，再次 merge 时按照写入顺序处理
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("a")))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("b")))
+	assert.Nil(t, db.Merge())
+	history, err := db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, []byte("b"), history[0].Value)
+
+	// 2.重启之后从 hint 文件加载，最新版本仍然有效
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	history, err = db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, []byte("b"), history[0].Value)
+	assert.Equal(t, []byte("a"), history[1].Value)
+}
+
+func TestDB_History_MergeDeletePrefix(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-6")
+	opt.DirPath = dir
+	opt.HistoryVersions = 3
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("a/1"), []byte("v1")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("v2")))
+	assert.Nil(t, db.DeletePrefix([]byte("a/")))
+
+	// 1.merge 之后被范围删除的 key 仍然不存在，删除版本保留在历史版本中
+	assert.Nil(t, db.Merge())
+	_, err = db.Get([]byte("a/1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	history, err := db.History([]byte("a/1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.True(t, history[0].Deleted)
+	assert.Equal(t, []byte("v1"), history[1].Value)
+
+	// 2.重启之后从 hint 文件加载，被删除的 key 不会重新出现
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("a/1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, db.ListKeys())
+	history, err = db.History([]byte("a/1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.True(t, history[0].Deleted)
+	assert.Equal(t, []byte("v1"), history[1].Value)
+
+	// 3.再次 merge 之后重启，删除记录仍然保留
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("a/1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, db.ListKeys())
+}
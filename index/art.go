@@ -0,0 +1,597 @@
+package index
+
+import (
+	"bytes"
+	"sync"
+	"unsafe"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// 自适应基数树的子节点布局，根据子节点数量在四种布局之间切换
+const (
+	artNode4 uint8 = iota
+	artNode16
+	artNode48
+	artNode256
+)
+
+// AdaptiveRadixTree 自适应基数树索引，共享前缀的 key 只存储一次前缀，点查询的复杂度只和 key 的长度有关
+type AdaptiveRadixTree struct {
+	root        *artNode
+	size        int
+	nodes       int    // 节点数量
+	kinds       [4]int // 每种布局的子节点数组数量
+	prefixBytes int64  // 所有节点的压缩路径长度之和
+	lock        *sync.RWMutex
+}
+
+// artNode 基数树节点，prefix 为压缩的路径，hasPos 为 true 表示有 key 在此节点结束，位置信息直接存储在节点中
+// key 在节点中结束并且没有更长的 key 时，节点没有子节点数组
+type artNode struct {
+	prefix   []byte
+	pos      data.LogRecordPos
+	hasPos   bool
+	children *artChildren
+}
+
+// artChildren 子节点数组，node4/node16 使用有序的 keys 和 nodes，node48 使用 index 映射到 nodes 的下标，node256 直接按字节下标存储
+type artChildren struct {
+	kind  uint8
+	num   int
+	keys  []byte
+	nodes []*artNode
+	index *[256]uint8 // 只用于 node48，值为 nodes 下标 + 1
+}
+
+// artNodeMemory 每个节点占用的内存，不包括压缩路径和子节点数组
+const artNodeMemory = int64(unsafe.Sizeof(artNode{}))
+
+// artChildrenMemory 每种布局的子节点数组占用的内存
+var artChildrenMemory = [...]int64{
+	artNode4:   int64(unsafe.Sizeof(artChildren{})) + 4 + 4*8,
+	artNode16:  int64(unsafe.Sizeof(artChildren{})) + 16 + 16*8,
+	artNode48:  int64(unsafe.Sizeof(artChildren{})) + 256 + 48*8,
+	artNode256: int64(unsafe.Sizeof(artChildren{})) + 256*8,
+}
+
+func NewAdaptiveRadixTree() *AdaptiveRadixTree {
+	return &AdaptiveRadixTree{
+		lock: new(sync.RWMutex),
+	}
+}
+
+func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) bool {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	if art.insert(&art.root, key, pos, 0) {
+		art.size++
+	}
+	return true
+}
+
+func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	node, depth := art.root, 0
+	for node != nil {
+		if !bytes.HasPrefix(key[depth:], node.prefix) {
+			return nil
+		}
+		depth += len(node.prefix)
+		if depth == len(key) {
+			if !node.hasPos {
+				return nil
+			}
+			pos := node.pos
+			return &pos
+		}
+		child := node.findChild(key[depth])
+		if child == nil {
+			return nil
+		}
+		node = *child
+		depth++
+	}
+	return nil
+}
+
+func (art *AdaptiveRadixTree) Delete(key []byte) bool {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	if !art.delete(&art.root, key, 0) {
+		return false
+	}
+	art.size--
+	return true
+}
+
+func (art *AdaptiveRadixTree) Size() int {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return art.size
+}
+
+// MemoryUsage 按照节点、子节点数组和压缩路径计算，共享的前缀只计算一次
+func (art *AdaptiveRadixTree) MemoryUsage() int64 {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	memory := int64(art.nodes)*artNodeMemory + art.prefixBytes
+	for kind, num := range art.kinds {
+		memory += int64(num) * artChildrenMemory[kind]
+	}
+	return memory
+}
+
+// Iterator 迭代器不复制整个索引，每次按需从树中读取一批数据，可以看到部分创建之后的修改
+func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	return &artIterator{
+		art:      art,
+		reverse:  reverse,
+		fromEdge: true,
+	}
+}
+
+// insert 将 key 插入到 ref 指向的子树中，depth 为已经匹配的 key 长度，新增 key 时返回 true
+func (art *AdaptiveRadixTree) insert(ref **artNode, key []byte, pos *data.LogRecordPos, depth int) bool {
+	node := *ref
+	if node == nil {
+		*ref = art.newLeaf(key[depth:], pos)
+		return true
+	}
+
+	// 前缀不完全匹配，在不匹配的位置分裂出新的节点
+	p := commonPrefixLen(node.prefix, key[depth:])
+	if p < len(node.prefix) {
+		parent := art.newNode(node.prefix[:p])
+		art.addChild(parent, node.prefix[p], node)
+		art.setPrefix(node, node.prefix[p+1:])
+		if depth+p == len(key) {
+			parent.pos, parent.hasPos = *pos, true
+		} else {
+			art.addChild(parent, key[depth+p], art.newLeaf(key[depth+p+1:], pos))
+		}
+		*ref = parent
+		return true
+	}
+
+	depth += len(node.prefix)
+	if depth == len(key) {
+		added := !node.hasPos
+		node.pos, node.hasPos = *pos, true
+		return added
+	}
+
+	if child := node.findChild(key[depth]); child != nil {
+		return art.insert(child, key, pos, depth+1)
+	}
+	art.addChild(node, key[depth], art.newLeaf(key[depth+1:], pos))
+	return true
+}
+
+// delete 从 ref 指向的子树中删除 key，返回 key 是否存在
+func (art *AdaptiveRadixTree) delete(ref **artNode, key []byte, depth int) bool {
+	node := *ref
+	if node == nil || !bytes.HasPrefix(key[depth:], node.prefix) {
+		return false
+	}
+	depth += len(node.prefix)
+	if depth == len(key) {
+		if !node.hasPos {
+			return false
+		}
+		node.pos, node.hasPos = data.LogRecordPos{}, false
+		art.compact(ref)
+		return true
+	}
+
+	c := key[depth]
+	child := node.findChild(c)
+	if child == nil || !art.delete(child, key, depth+1) {
+		return false
+	}
+	if *child == nil {
+		art.removeChild(node, c)
+	}
+	art.compact(ref)
+	return true
+}
+
+// compact 删除之后整理节点，没有数据的节点被删除，只有一个子节点的节点和子节点合并
+func (art *AdaptiveRadixTree) compact(ref **artNode) {
+	node := *ref
+	if node.hasPos || node.numChildren() > 1 {
+		return
+	}
+	if node.numChildren() == 0 {
+		art.freeNode(node)
+		*ref = nil
+		return
+	}
+
+	var c byte
+	var child *artNode
+	node.forEachChild(false, func(b byte, n *artNode) bool {
+		c, child = b, n
+		return false
+	})
+	prefix := make([]byte, 0, len(node.prefix)+1+len(child.prefix))
+	prefix = append(prefix, node.prefix...)
+	prefix = append(prefix, c)
+	prefix = append(prefix, child.prefix...)
+	art.setPrefix(child, prefix)
+	art.freeNode(node)
+	*ref = child
+}
+
+// newNode 创建没有数据的节点，复制压缩路径，不引用写入的 key
+func (art *AdaptiveRadixTree) newNode(prefix []byte) *artNode {
+	node := &artNode{}
+	art.nodes++
+	art.setPrefix(node, prefix)
+	return node
+}
+
+func (art *AdaptiveRadixTree) newLeaf(prefix []byte, pos *data.LogRecordPos) *artNode {
+	node := art.newNode(prefix)
+	node.pos, node.hasPos = *pos, true
+	return node
+}
+
+// freeNode 节点从树中移除之后更新内存统计
+func (art *AdaptiveRadixTree) freeNode(node *artNode) {
+	art.nodes--
+	art.setPrefix(node, nil)
+	if node.children != nil {
+		art.kinds[node.children.kind]--
+		node.children = nil
+	}
+}
+
+// setPrefix 复制一份新的压缩路径
+func (art *AdaptiveRadixTree) setPrefix(node *artNode, prefix []byte) {
+	art.prefixBytes += int64(len(prefix) - len(node.prefix))
+	if len(prefix) == 0 {
+		node.prefix = nil
+		return
+	}
+	node.prefix = make([]byte, len(prefix))
+	copy(node.prefix, prefix)
+}
+
+// addChild 添加子节点，子节点数组已满时切换到更大的布局
+func (art *AdaptiveRadixTree) addChild(node *artNode, c byte, child *artNode) {
+	if node.children == nil {
+		node.children = newArtChildren(artNode4)
+		art.kinds[artNode4]++
+	}
+	if node.children.full() {
+		art.resize(node, node.children.kind+1)
+	}
+	node.children.insert(c, child)
+}
+
+// removeChild 删除子节点，子节点较少时切换到更小的布局，没有子节点时释放子节点数组
+func (art *AdaptiveRadixTree) removeChild(node *artNode, c byte) {
+	children := node.children
+	children.remove(c)
+	switch {
+	case children.num == 0:
+		art.kinds[children.kind]--
+		node.children = nil
+	case children.kind == artNode16 && children.num <= 3,
+		children.kind == artNode48 && children.num <= 12,
+		children.kind == artNode256 && children.num <= 37:
+		art.resize(node, children.kind-1)
+	}
+}
+
+// resize 切换子节点数组的布局
+func (art *AdaptiveRadixTree) resize(node *artNode, kind uint8) {
+	resized := newArtChildren(kind)
+	node.forEachChild(false, func(c byte, child *artNode) bool {
+		resized.insert(c, child)
+		return true
+	})
+	art.kinds[node.children.kind]--
+	art.kinds[kind]++
+	node.children = resized
+}
+
+func newArtChildren(kind uint8) *artChildren {
+	children := &artChildren{kind: kind}
+	switch kind {
+	case artNode4:
+		children.keys = make([]byte, 0, 4)
+		children.nodes = make([]*artNode, 0, 4)
+	case artNode16:
+		children.keys = make([]byte, 0, 16)
+		children.nodes = make([]*artNode, 0, 16)
+	case artNode48:
+		children.index = new([256]uint8)
+		children.nodes = make([]*artNode, 48)
+	case artNode256:
+		children.nodes = make([]*artNode, 256)
+	}
+	return children
+}
+
+func (ac *artChildren) full() bool {
+	switch ac.kind {
+	case artNode4, artNode16:
+		return ac.num == cap(ac.keys)
+	case artNode48:
+		return ac.num == 48
+	default:
+		return false
+	}
+}
+
+// insert 在当前布局中添加子节点，调用之前必须保证还有空间
+func (ac *artChildren) insert(c byte, child *artNode) {
+	switch ac.kind {
+	case artNode4, artNode16:
+		i := 0
+		for i < ac.num && ac.keys[i] < c {
+			i++
+		}
+		ac.keys = append(ac.keys, 0)
+		copy(ac.keys[i+1:], ac.keys[i:])
+		ac.keys[i] = c
+		ac.nodes = append(ac.nodes, nil)
+		copy(ac.nodes[i+1:], ac.nodes[i:])
+		ac.nodes[i] = child
+	case artNode48:
+		slot := 0
+		for ac.nodes[slot] != nil {
+			slot++
+		}
+		ac.nodes[slot] = child
+		ac.index[c] = uint8(slot + 1)
+	case artNode256:
+		ac.nodes[c] = child
+	}
+	ac.num++
+}
+
+func (ac *artChildren) remove(c byte) {
+	switch ac.kind {
+	case artNode4, artNode16:
+		for i, k := range ac.keys {
+			if k == c {
+				last := len(ac.keys) - 1
+				ac.keys = append(ac.keys[:i], ac.keys[i+1:]...)
+				copy(ac.nodes[i:], ac.nodes[i+1:])
+				ac.nodes[last] = nil
+				ac.nodes = ac.nodes[:last]
+				break
+			}
+		}
+	case artNode48:
+		ac.nodes[ac.index[c]-1] = nil
+		ac.index[c] = 0
+	case artNode256:
+		ac.nodes[c] = nil
+	}
+	ac.num--
+}
+
+func (n *artNode) numChildren() int {
+	if n.children == nil {
+		return 0
+	}
+	return n.children.num
+}
+
+// findChild 查找字节 c 对应的子节点，返回子节点在父节点中的位置
+func (n *artNode) findChild(c byte) **artNode {
+	ac := n.children
+	if ac == nil {
+		return nil
+	}
+	switch ac.kind {
+	case artNode4, artNode16:
+		for i, k := range ac.keys {
+			if k == c {
+				return &ac.nodes[i]
+			}
+			if k > c {
+				break
+			}
+		}
+	case artNode48:
+		if slot := ac.index[c]; slot > 0 {
+			return &ac.nodes[slot-1]
+		}
+	case artNode256:
+		if ac.nodes[c] != nil {
+			return &ac.nodes[c]
+		}
+	}
+	return nil
+}
+
+// forEachChild 按字节顺序遍历子节点，fn 返回 false 时停止
+func (n *artNode) forEachChild(reverse bool, fn func(c byte, child *artNode) bool) {
+	ac := n.children
+	if ac == nil {
+		return
+	}
+	switch ac.kind {
+	case artNode4, artNode16:
+		for i := range ac.keys {
+			if reverse {
+				i = len(ac.keys) - 1 - i
+			}
+			if !fn(ac.keys[i], ac.nodes[i]) {
+				return
+			}
+		}
+	case artNode48, artNode256:
+		for i := 0; i < 256; i++ {
+			c := byte(i)
+			if reverse {
+				c = byte(255 - i)
+			}
+			var child *artNode
+			if ac.kind == artNode48 {
+				if slot := ac.index[c]; slot > 0 {
+					child = ac.nodes[slot-1]
+				}
+			} else {
+				child = ac.nodes[c]
+			}
+			if child != nil && !fn(c, child) {
+				return
+			}
+		}
+	}
+}
+
+// scan 按 key 的顺序遍历子树，较短的 key 排在以它为前缀的 key 之前，fn 返回 false 时停止遍历并返回 false
+// path 为到达此节点之前的 key，bounded 为 true 时只遍历大于等于（反向时小于等于）pivot 的 key，调用时必须持有读锁
+func (n *artNode) scan(path, pivot []byte, bounded, reverse bool, fn func(key []byte, pos data.LogRecordPos) bool) bool {
+	path = append(path, n.prefix...)
+	self, split := n.hasPos, -1
+	if bounded {
+		m := min(len(path), len(pivot))
+		cmp := bytes.Compare(path[:m], pivot[:m])
+		if reverse {
+			cmp = -cmp
+		}
+		switch {
+		case cmp < 0:
+			// 整个子树都在 pivot 之前
+			return true
+		case cmp > 0, len(path) > len(pivot) && !reverse:
+			bounded = false
+		case len(path) > len(pivot):
+			return true
+		case len(path) == len(pivot):
+			// 子节点的 key 都大于 pivot，正向时全部遍历，反向时全部跳过
+			if reverse {
+				return !self || fn(path, n.pos)
+			}
+			bounded = false
+		default:
+			// path 是 pivot 的前缀，只有 pivot 的下一个字节对应的子节点需要继续比较
+			self = self && reverse
+			split = int(pivot[len(path)])
+		}
+	}
+
+	if !reverse && self && !fn(path, n.pos) {
+		return false
+	}
+	ok := true
+	n.forEachChild(reverse, func(c byte, child *artNode) bool {
+		childBounded := bounded && int(c) == split
+		if bounded && !childBounded && (int(c) < split) != reverse {
+			return true
+		}
+		ok = child.scan(append(path, c), pivot, childBounded, reverse, fn)
+		return ok
+	})
+	if !ok {
+		return false
+	}
+	if reverse && self {
+		return fn(path, n.pos)
+	}
+	return true
+}
+
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// artIteratorBatch 迭代器每次从树中读取的数据量
+const artIteratorBatch = 64
+
+// ART 索引迭代器，每次持有读锁从上一批数据的最后一个 key 之后继续读取一批数据
+type artIterator struct {
+	art      *AdaptiveRadixTree
+	reverse  bool    // 是否反向遍历
+	items    []*Item // 当前读取的一批数据，key 和位置信息都是复制出来的
+	currIdx  int     // 当前遍历的下标位置
+	loaded   bool    // 是否已经读取了当前位置的数据
+	pivot    []byte  // 下一批数据的起点，为 nil 并且 fromEdge 为 true 时从头开始
+	fromEdge bool
+	exclude  bool // 下一批数据是否跳过 pivot 本身
+}
+
+func (ai *artIterator) Rewind() {
+	ai.reset(nil, true)
+}
+
+func (ai *artIterator) Seek(key []byte) {
+	ai.reset(key, false)
+}
+
+func (ai *artIterator) Next() {
+	ai.load()
+	if ai.currIdx >= len(ai.items) {
+		return
+	}
+	ai.currIdx++
+	// 当前一批数据已经读完，并且树中可能还有数据，从最后一个 key 之后继续读取
+	if ai.currIdx == len(ai.items) && len(ai.items) == artIteratorBatch {
+		ai.reset(ai.items[len(ai.items)-1].key, false)
+		ai.exclude = true
+	}
+}
+
+func (ai *artIterator) Valid() bool {
+	ai.load()
+	return ai.currIdx < len(ai.items)
+}
+
+func (ai *artIterator) Key() []byte {
+	ai.load()
+	return ai.items[ai.currIdx].key
+}
+
+func (ai *artIterator) Value() *data.LogRecordPos {
+	ai.load()
+	return ai.items[ai.currIdx].pos
+}
+
+func (ai *artIterator) Close() {
+	ai.art = nil
+	ai.items = nil
+	ai.loaded = true
+}
+
+// reset 移动到新的起点，数据在第一次访问时才读取
+func (ai *artIterator) reset(pivot []byte, fromEdge bool) {
+	ai.pivot, ai.fromEdge, ai.exclude = pivot, fromEdge, false
+	ai.loaded = false
+}
+
+// load 从树中读取一批数据
+func (ai *artIterator) load() {
+	if ai.loaded {
+		return
+	}
+	ai.loaded = true
+	ai.items = make([]*Item, 0, artIteratorBatch)
+	ai.currIdx = 0
+
+	ai.art.lock.RLock()
+	defer ai.art.lock.RUnlock()
+	if ai.art.root == nil {
+		return
+	}
+	ai.art.root.scan(nil, ai.pivot, !ai.fromEdge, ai.reverse, func(key []byte, pos data.LogRecordPos) bool {
+		if ai.exclude && bytes.Equal(key, ai.pivot) {
+			return true
+		}
+		ai.items = append(ai.items, &Item{key: append([]byte{}, key...), pos: &pos})
+		return len(ai.items) < artIteratorBatch
+	})
+}
@@ -0,0 +1,221 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+func TestART_Put(t *testing.T) {
+	art := NewAdaptiveRadixTree()
+
+	res1 := art.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.True(t, res1)
+
+	res2 := art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.True(t, res2)
+	assert.Equal(t, 2, art.Size())
+
+	// 覆盖已有的 key，数量不变
+	res3 := art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.True(t, res3)
+	assert.Equal(t, 2, art.Size())
+}
+
+func TestART_Get(t *testing.T) {
+	art := NewAdaptiveRadixTree()
+
+	art.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	pos1 := art.Get(nil)
+	assert.Equal(t, uint32(1), pos1.Fid)
+	assert.Equal(t, int64(100), pos1.Offset)
+
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	pos2 := art.Get([]byte("a"))
+	assert.Equal(t, int64(3), pos2.Offset)
+
+	// key 互为前缀
+	art.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 4})
+	art.Put([]byte("ab"), &data.LogRecordPos{Fid: 1, Offset: 5})
+	assert.Equal(t, int64(4), art.Get([]byte("abc")).Offset)
+	assert.Equal(t, int64(5), art.Get([]byte("ab")).Offset)
+	assert.Equal(t, int64(3), art.Get([]byte("a")).Offset)
+	assert.Nil(t, art.Get([]byte("abcd")))
+	assert.Nil(t, art.Get([]byte("b")))
+}
+
+func TestART_Delete(t *testing.T) {
+	art := NewAdaptiveRadixTree()
+	art.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.True(t, art.Delete(nil))
+	assert.False(t, art.Delete(nil))
+
+	art.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 33})
+	art.Put([]byte("aab"), &data.LogRecordPos{Fid: 22, Offset: 34})
+	assert.False(t, art.Delete([]byte("aa")))
+	assert.True(t, art.Delete([]byte("aaa")))
+	assert.Nil(t, art.Get([]byte("aaa")))
+	assert.Equal(t, int64(34), art.Get([]byte("aab")).Offset)
+	assert.Equal(t, 1, art.Size())
+}
+
+func TestART_Iterator(t *testing.T) {
+	art := NewAdaptiveRadixTree()
+	// 1.ART 为空
+	iter1 := art.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	// 2.有数据，包括互为前缀的 key
+	keys := []string{"ccde", "acee", "bbcd", "bbed", "cc", "c", "bb"}
+	for i, key := range keys {
+		art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	var got []string
+	for iter := art.Iterator(false); iter.Valid(); iter.Next() {
+		got = append(got, string(iter.Key()))
+	}
+	assert.Equal(t, sorted, got)
+
+	got = got[:0]
+	for iter := art.Iterator(true); iter.Valid(); iter.Next() {
+		got = append(got, string(iter.Key()))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	assert.Equal(t, sorted, got)
+
+	// 3.Seek
+	iter2 := art.Iterator(false)
+	iter2.Seek([]byte("bc"))
+	assert.Equal(t, "c", string(iter2.Key()))
+	iter3 := art.Iterator(true)
+	iter3.Seek([]byte("bc"))
+	assert.Equal(t, "bbed", string(iter3.Key()))
+}
+
+// 随机写入和删除，结果与 BTree 保持一致，覆盖节点的扩容和缩容
+func TestART_CompareWithBTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	art, bt := NewAdaptiveRadixTree(), NewBTree()
+
+	randKey := func() []byte {
+		key := make([]byte, rnd.Intn(4))
+		for i := range key {
+			// 字节范围足够大，使节点可以扩容到 node256
+			key[i] = byte(rnd.Intn(256))
+		}
+		return key
+	}
+	check := func() {
+		assert.Equal(t, bt.Size(), art.Size())
+		assert.Equal(t, artMemory(art.root), art.MemoryUsage())
+		for _, reverse := range []bool{false, true} {
+			iter1, iter2 := bt.Iterator(reverse), art.Iterator(reverse)
+			for iter1.Valid() {
+				if !assert.True(t, iter2.Valid()) {
+					return
+				}
+				assert.True(t, bytes.Equal(iter1.Key(), iter2.Key()))
+				assert.Equal(t, iter1.Value(), iter2.Value())
+				iter1.Next()
+				iter2.Next()
+			}
+			assert.False(t, iter2.Valid())
+
+			seek := randKey()
+			iter1.Seek(seek)
+			iter2.Seek(seek)
+			assert.Equal(t, iter1.Valid(), iter2.Valid())
+			if iter1.Valid() {
+				assert.Equal(t, iter1.Key(), iter2.Key(), fmt.Sprintf("seek %v", seek))
+			}
+		}
+	}
+
+	var written [][]byte
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 3000; i++ {
+			key := randKey()
+			written = append(written, key)
+			pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i)}
+			bt.Put(key, pos)
+			art.Put(key, pos)
+			assert.Equal(t, bt.Get(key), art.Get(key))
+		}
+		check()
+
+		// 删除大部分写入过的 key，使节点缩容
+		for i := 0; i < 2500; i++ {
+			key := written[rnd.Intn(len(written))]
+			assert.Equal(t, bt.Delete(key), art.Delete(key))
+			assert.Nil(t, art.Get(key))
+		}
+		check()
+	}
+}
+
+// artMemory 遍历整个子树重新计算占用的内存，用于检查增量统计的结果
+func artMemory(n *artNode) int64 {
+	if n == nil {
+		return 0
+	}
+	memory := artNodeMemory + int64(len(n.prefix))
+	if n.children != nil {
+		memory += artChildrenMemory[n.children.kind]
+	}
+	n.forEachChild(false, func(_ byte, child *artNode) bool {
+		memory += artMemory(child)
+		return true
+	})
+	return memory
+}
+
+func TestART_MemoryUsage(t *testing.T) {
+	art, bt := NewAdaptiveRadixTree(), NewBTree()
+
+	// 1.共享前缀的 key 占用的内存不超过 BTree
+	for i := 0; i < 100000; i++ {
+		key := []byte(fmt.Sprintf("bitcask-go-key-%09d", i))
+		pos := &data.LogRecordPos{Fid: 1, Offset: int64(i)}
+		art.Put(key, pos)
+		bt.Put(key, pos)
+	}
+	assert.True(t, art.MemoryUsage() <= bt.MemoryUsage())
+	assert.Equal(t, artMemory(art.root), art.MemoryUsage())
+
+	// 2.不引用写入的 key，修改 key 之后不影响索引
+	key := []byte("bitcask-go-key-x")
+	art.Put(key, &data.LogRecordPos{Fid: 2})
+	key[len(key)-1] = 'y'
+	assert.NotNil(t, art.Get([]byte("bitcask-go-key-x")))
+	assert.Nil(t, art.Get([]byte("bitcask-go-key-y")))
+}
+
+// 迭代器分批读取，遍历过程中的写入不影响已经读取的数据
+func TestART_Iterator_Batch(t *testing.T) {
+	art := NewAdaptiveRadixTree()
+	for i := 0; i < 1000; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter := art.Iterator(false)
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", count), string(iter.Key()))
+		assert.Equal(t, int64(count), iter.Value().Offset)
+		// 删除已经遍历过的 key
+		art.Delete(iter.Key())
+		count++
+	}
+	assert.Equal(t, 1000, count)
+	assert.Equal(t, 0, art.Size())
+	assert.Equal(t, int64(0), art.MemoryUsage())
+}
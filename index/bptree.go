@@ -0,0 +1,469 @@
+package index
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// BPlusTree 存储在磁盘上的 B+ 树索引，内存中只缓存部分页面，可以支持超过内存大小的 key 集合
+// 修改在调用 Checkpoint 之后才持久化，崩溃之后恢复到上一次 checkpoint 的状态，调用方需要重放之后的数据
+type BPlusTree struct {
+	pager *bptreePager
+	root  uint32
+	count int
+	lock  *sync.RWMutex
+}
+
+// BPTreeMaxKeyLen B+ 树索引中 key 的最大长度
+const BPTreeMaxKeyLen = bptreeMaxKeyLen
+
+// bptPathElem 从根节点查找时经过的节点，idx 为子节点的下标
+type bptPathElem struct {
+	node *bptNode
+	idx  int
+}
+
+// NewBPlusTree 打开 path 对应的索引文件，不存在时创建，cacheSize 为页面缓存的字节数
+func NewBPlusTree(path string, cacheSize int64) (*BPlusTree, error) {
+	pager, err := openPager(path, cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &BPlusTree{
+		pager: pager,
+		root:  pager.meta.root,
+		count: int(pager.meta.count),
+		lock:  new(sync.RWMutex),
+	}, nil
+}
+
+// Put key 的长度不能超过 1024 字节，读写索引文件失败时返回 false
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
+	if len(key) > bptreeMaxKeyLen {
+		return false
+	}
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	defer bpt.pager.evict()
+	if bpt.pager.failed() != nil {
+		return false
+	}
+
+	path, err := bpt.findPath(key)
+	if err != nil {
+		return false
+	}
+	bpt.makeWritable(path)
+
+	leaf := path[len(path)-1].node
+	i, found := leaf.search(key)
+	if found {
+		leaf.values[i] = pos
+		return true
+	}
+	leaf.keys = insertAt(leaf.keys, i, append([]byte(nil), key...))
+	leaf.values = insertAt(leaf.values, i, pos)
+	bpt.count++
+	bpt.splitPath(path)
+	return true
+}
+
+func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	defer bpt.pager.evict()
+
+	node, err := bpt.pager.node(bpt.root)
+	for err == nil && !node.leaf {
+		node, err = bpt.pager.node(node.children[node.childIndex(key)])
+	}
+	if err != nil {
+		return nil
+	}
+	if i, found := node.search(key); found {
+		return node.values[i]
+	}
+	return nil
+}
+
+func (bpt *BPlusTree) Delete(key []byte) bool {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	defer bpt.pager.evict()
+	if bpt.pager.failed() != nil {
+		return false
+	}
+
+	path, err := bpt.findPath(key)
+	if err != nil {
+		return false
+	}
+	i, found := path[len(path)-1].node.search(key)
+	if !found {
+		return false
+	}
+	bpt.makeWritable(path)
+
+	leaf := path[len(path)-1].node
+	leaf.keys = removeAt(leaf.keys, i)
+	leaf.values = removeAt(leaf.values, i)
+	bpt.count--
+
+	// 删除之后为空的节点从父节点中移除，不合并数据较少的节点
+	level := len(path) - 1
+	for level > 0 && path[level].node.empty() {
+		bpt.pager.release(path[level].node.id)
+		parent := path[level-1]
+		parent.node.children = removeAt(parent.node.children, parent.idx)
+		if parent.idx > 0 {
+			parent.node.keys = removeAt(parent.node.keys, parent.idx-1)
+		} else if len(parent.node.keys) > 0 {
+			parent.node.keys = removeAt(parent.node.keys, 0)
+		}
+		level--
+	}
+	if err := bpt.shrinkRoot(); err != nil {
+		return false
+	}
+	return true
+}
+
+func (bpt *BPlusTree) Size() int {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	return bpt.count
+}
+
+// MemoryUsage 索引数据保存在文件中，只有缓存的页面占用内存，上限为页面缓存的大小
+func (bpt *BPlusTree) MemoryUsage() int64 {
+	return int64(bpt.pager.cached()) * bptreePageSize
+}
+
+// Iterator 每次从索引文件中读取一个叶子节点的数据，不会一次性加载所有 key
+func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
+	iter := &bptreeIterator{tree: bpt, reverse: reverse}
+	iter.Rewind()
+	return iter
+}
+
+// Checkpoint 持久化所有修改，meta 为调用方的元信息，和索引数据一起原子地写入
+func (bpt *BPlusTree) Checkpoint(meta []byte) error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	defer bpt.pager.evict()
+	return bpt.pager.commit(bpt.root, bpt.count, append([]byte(nil), meta...))
+}
+
+// CheckpointMeta 最近一次 checkpoint 写入的调用方元信息
+func (bpt *BPlusTree) CheckpointMeta() []byte {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	return bpt.pager.userMeta
+}
+
+// Close 关闭索引文件，上一次 checkpoint 之后的修改会丢失
+func (bpt *BPlusTree) Close() error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	return bpt.pager.close()
+}
+
+// findPath 从根节点查找 key 所在的叶子节点，返回经过的所有节点
+func (bpt *BPlusTree) findPath(key []byte) ([]bptPathElem, error) {
+	var path []bptPathElem
+	id := bpt.root
+	for {
+		node, err := bpt.pager.node(id)
+		if err != nil {
+			return nil, err
+		}
+		if node.leaf {
+			return append(path, bptPathElem{node: node}), nil
+		}
+		idx := node.childIndex(key)
+		path = append(path, bptPathElem{node: node, idx: idx})
+		id = node.children[idx]
+	}
+}
+
+// makeWritable 从根节点开始将路径上的节点复制到可以修改的页面，并更新父节点中的页面 id
+func (bpt *BPlusTree) makeWritable(path []bptPathElem) {
+	for i, elem := range path {
+		bpt.pager.writable(elem.node)
+		if i == 0 {
+			bpt.root = elem.node.id
+		} else {
+			path[i-1].node.children[path[i-1].idx] = elem.node.id
+		}
+	}
+}
+
+// splitPath 从叶子节点开始，将超过页面大小的节点分裂为两个节点，分隔 key 插入到父节点中
+func (bpt *BPlusTree) splitPath(path []bptPathElem) {
+	for level := len(path) - 1; level >= 0; level-- {
+		node := path[level].node
+		if node.size() <= bptreePageSize {
+			return
+		}
+		sep, right := node.split()
+		right.id = bpt.pager.alloc()
+		bpt.pager.add(right)
+
+		if level == 0 {
+			root := &bptNode{
+				id:       bpt.pager.alloc(),
+				dirty:    true,
+				keys:     [][]byte{sep},
+				children: []uint32{node.id, right.id},
+			}
+			bpt.pager.add(root)
+			bpt.root = root.id
+			return
+		}
+		parent := path[level-1]
+		parent.node.keys = insertAt(parent.node.keys, parent.idx, sep)
+		parent.node.children = insertAt(parent.node.children, parent.idx+1, right.id)
+	}
+}
+
+// shrinkRoot 根节点只有一个子节点时，使用子节点作为新的根节点
+func (bpt *BPlusTree) shrinkRoot() error {
+	for {
+		root, err := bpt.pager.node(bpt.root)
+		if err != nil {
+			return err
+		}
+		if root.leaf {
+			return nil
+		}
+		switch len(root.children) {
+		case 0:
+			// 所有数据都已经被删除
+			bpt.pager.release(root.id)
+			leaf := &bptNode{id: bpt.pager.alloc(), leaf: true, dirty: true}
+			bpt.pager.add(leaf)
+			bpt.root = leaf.id
+			return nil
+		case 1:
+			bpt.pager.release(root.id)
+			bpt.root = root.children[0]
+		default:
+			return nil
+		}
+	}
+}
+
+// seek 从 key 开始读取一个叶子节点中满足条件的数据，按照遍历的顺序排列
+// edge 为 true 时从第一个（反向遍历时为最后一个）key 开始，inclusive 表示是否包括 key 本身
+func (bpt *BPlusTree) seek(key []byte, reverse bool, inclusive bool, edge bool) []*Item {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	defer bpt.pager.evict()
+
+	// 找到 key 所在的叶子节点
+	var path []bptPathElem
+	id := bpt.root
+	for {
+		node, err := bpt.pager.node(id)
+		if err != nil {
+			return nil
+		}
+		if node.leaf {
+			path = append(path, bptPathElem{node: node})
+			break
+		}
+		idx := node.childIndex(key)
+		if edge {
+			idx = 0
+			if reverse {
+				idx = len(node.children) - 1
+			}
+		}
+		path = append(path, bptPathElem{node: node, idx: idx})
+		id = node.children[idx]
+	}
+
+	items := path[len(path)-1].node.collect(key, reverse, inclusive, edge)
+	for len(items) == 0 {
+		// 当前叶子节点中没有满足条件的数据，移动到相邻的叶子节点
+		level := len(path) - 2
+		for ; level >= 0; level-- {
+			next := path[level].idx + 1
+			if reverse {
+				next = path[level].idx - 1
+			}
+			if next >= 0 && next < len(path[level].node.children) {
+				path[level].idx = next
+				break
+			}
+		}
+		if level < 0 {
+			return nil
+		}
+		path = path[:level+1]
+		id = path[level].node.children[path[level].idx]
+		for {
+			node, err := bpt.pager.node(id)
+			if err != nil {
+				return nil
+			}
+			if node.leaf {
+				path = append(path, bptPathElem{node: node})
+				break
+			}
+			idx := 0
+			if reverse {
+				idx = len(node.children) - 1
+			}
+			path = append(path, bptPathElem{node: node, idx: idx})
+			id = node.children[idx]
+		}
+		items = path[len(path)-1].node.collect(key, reverse, inclusive, true)
+	}
+	return items
+}
+
+// search 在叶子节点中查找 key，返回 key 所在或者应该插入的位置
+func (n *bptNode) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// childIndex 在内部节点中查找 key 所在的子节点
+func (n *bptNode) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+func (n *bptNode) empty() bool {
+	if n.leaf {
+		return len(n.keys) == 0
+	}
+	return len(n.children) == 0
+}
+
+// split 将节点按照编码之后的大小分成两半，返回分隔 key 和右边的新节点
+// 叶子节点的分隔 key 为右边节点的第一个 key，内部节点的分隔 key 从节点中移到父节点
+func (n *bptNode) split() ([]byte, *bptNode) {
+	half, size, mid := n.size()/2, bptreeNodeHeaderSize, 0
+	for mid < len(n.keys)-1 {
+		if n.leaf {
+			size += 2 + len(n.keys[mid]) + bptreePosSize
+		} else {
+			size += 2 + len(n.keys[mid]) + 4
+		}
+		if size > half {
+			break
+		}
+		mid++
+	}
+	if mid == 0 {
+		mid = 1
+	}
+	if !n.leaf && mid > len(n.keys)-2 {
+		mid = len(n.keys) - 2
+	}
+
+	right := &bptNode{leaf: n.leaf, dirty: true}
+	if n.leaf {
+		right.keys = append([][]byte(nil), n.keys[mid:]...)
+		right.values = append([]*data.LogRecordPos(nil), n.values[mid:]...)
+		n.keys = append([][]byte(nil), n.keys[:mid]...)
+		n.values = append([]*data.LogRecordPos(nil), n.values[:mid]...)
+		return right.keys[0], right
+	}
+	sep := n.keys[mid]
+	right.keys = append([][]byte(nil), n.keys[mid+1:]...)
+	right.children = append([]uint32(nil), n.children[mid+1:]...)
+	n.keys = append([][]byte(nil), n.keys[:mid]...)
+	n.children = append([]uint32(nil), n.children[:mid+1]...)
+	return sep, right
+}
+
+// collect 复制叶子节点中满足条件的数据
+func (n *bptNode) collect(key []byte, reverse bool, inclusive bool, all bool) []*Item {
+	var items []*Item
+	for i := range n.keys {
+		if reverse {
+			i = len(n.keys) - 1 - i
+		}
+		if !all {
+			cmp := bytes.Compare(n.keys[i], key)
+			if reverse {
+				cmp = -cmp
+			}
+			if cmp < 0 || (cmp == 0 && !inclusive) {
+				continue
+			}
+		}
+		items = append(items, &Item{key: n.keys[i], pos: n.values[i]})
+	}
+	return items
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
+
+// bptreeIterator B+ 树索引迭代器，缓存一个叶子节点中的数据，读完之后从最后一个 key 继续查找
+type bptreeIterator struct {
+	tree    *BPlusTree
+	reverse bool
+	items   []*Item
+	idx     int
+}
+
+func (iter *bptreeIterator) Rewind() {
+	iter.items = iter.tree.seek(nil, iter.reverse, true, true)
+	iter.idx = 0
+}
+
+func (iter *bptreeIterator) Seek(key []byte) {
+	iter.items = iter.tree.seek(key, iter.reverse, true, false)
+	iter.idx = 0
+}
+
+func (iter *bptreeIterator) Next() {
+	iter.idx++
+	if iter.idx < len(iter.items) {
+		return
+	}
+	if len(iter.items) > 0 {
+		last := iter.items[len(iter.items)-1].key
+		iter.items = iter.tree.seek(last, iter.reverse, false, false)
+	}
+	iter.idx = 0
+}
+
+func (iter *bptreeIterator) Valid() bool {
+	return iter.idx < len(iter.items)
+}
+
+func (iter *bptreeIterator) Key() []byte {
+	return iter.items[iter.idx].key
+}
+
+func (iter *bptreeIterator) Value() *data.LogRecordPos {
+	return iter.items[iter.idx].pos
+}
+
+func (iter *bptreeIterator) Close() {
+	iter.items = nil
+}
@@ -0,0 +1,510 @@
+package index
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+var (
+	ErrIndexFileCorrupted = errors.New("the index file is corrupted")
+)
+
+const (
+	bptreePageSize  = 4096
+	bptreeMagic     = 0x62707431 // "bpt1"
+	bptreeRootPage  = 2          // 前两个页面是交替写入的元信息页
+	bptreeMaxKeyLen = 1024       // 保证一个页面至少可以存储 3 个 key
+
+	bptreeLeafPage   uint8 = 1
+	bptreeBranchPage uint8 = 2
+	bptreeBlobPage   uint8 = 3
+
+	bptreeNodeHeaderSize = 1 + 2     // 页面类型 + key 数量
+	bptreeBlobHeaderSize = 1 + 4 + 2 // 页面类型 + 下一个页面 + 数据长度
+	bptreePosSize        = 4 + 8 + 4 // Fid + Offset + Size
+)
+
+// bptreeMeta 元信息页，记录最近一次 checkpoint 时树的状态
+type bptreeMeta struct {
+	txid      uint64 // checkpoint 序号，加载时以序号最大的有效元信息为准
+	root      uint32
+	pageCount uint32 // 文件中已经分配的页面数量
+	count     uint64 // key 的数量
+	blob      uint32 // 存储空闲页面和用户元信息的第一个页面，为 0 表示没有
+}
+
+// bptNode 解码之后的页面，叶子节点存储 key 和位置信息，内部节点第 i 个子节点中的 key 在 [keys[i-1], keys[i]) 之间
+type bptNode struct {
+	id       uint32
+	leaf     bool
+	dirty    bool
+	keys     [][]byte
+	values   []*data.LogRecordPos
+	children []uint32
+}
+
+// size 节点编码之后的大小
+func (n *bptNode) size() int {
+	size := bptreeNodeHeaderSize
+	if !n.leaf {
+		size += 4
+	}
+	for _, key := range n.keys {
+		if n.leaf {
+			size += 2 + len(key) + bptreePosSize
+		} else {
+			size += 2 + len(key) + 4
+		}
+	}
+	return size
+}
+
+func (n *bptNode) encode() []byte {
+	buf := make([]byte, bptreePageSize)
+	if n.leaf {
+		buf[0] = bptreeLeafPage
+	} else {
+		buf[0] = bptreeBranchPage
+	}
+	binary.LittleEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+	index := bptreeNodeHeaderSize
+	if !n.leaf {
+		binary.LittleEndian.PutUint32(buf[index:], n.children[0])
+		index += 4
+	}
+	for i, key := range n.keys {
+		binary.LittleEndian.PutUint16(buf[index:], uint16(len(key)))
+		index += 2
+		index += copy(buf[index:], key)
+		if n.leaf {
+			pos := n.values[i]
+			binary.LittleEndian.PutUint32(buf[index:], pos.Fid)
+			binary.LittleEndian.PutUint64(buf[index+4:], uint64(pos.Offset))
+			binary.LittleEndian.PutUint32(buf[index+12:], pos.Size)
+			index += bptreePosSize
+		} else {
+			binary.LittleEndian.PutUint32(buf[index:], n.children[i+1])
+			index += 4
+		}
+	}
+	return buf
+}
+
+func decodeNode(id uint32, buf []byte) (*bptNode, error) {
+	if buf[0] != bptreeLeafPage && buf[0] != bptreeBranchPage {
+		return nil, ErrIndexFileCorrupted
+	}
+	n := &bptNode{id: id, leaf: buf[0] == bptreeLeafPage}
+	num := int(binary.LittleEndian.Uint16(buf[1:]))
+	index := bptreeNodeHeaderSize
+	if !n.leaf {
+		n.children = append(make([]uint32, 0, num+1), binary.LittleEndian.Uint32(buf[index:]))
+		index += 4
+	} else {
+		n.values = make([]*data.LogRecordPos, 0, num)
+	}
+	n.keys = make([][]byte, 0, num)
+	for i := 0; i < num; i++ {
+		if index+2 > len(buf) {
+			return nil, ErrIndexFileCorrupted
+		}
+		keyLen := int(binary.LittleEndian.Uint16(buf[index:]))
+		index += 2
+		end := index + keyLen + 4
+		if n.leaf {
+			end = index + keyLen + bptreePosSize
+		}
+		if end > len(buf) {
+			return nil, ErrIndexFileCorrupted
+		}
+		n.keys = append(n.keys, append([]byte(nil), buf[index:index+keyLen]...))
+		index += keyLen
+		if n.leaf {
+			n.values = append(n.values, &data.LogRecordPos{
+				Fid:    binary.LittleEndian.Uint32(buf[index:]),
+				Offset: int64(binary.LittleEndian.Uint64(buf[index+4:])),
+				Size:   binary.LittleEndian.Uint32(buf[index+12:]),
+			})
+		} else {
+			n.children = append(n.children, binary.LittleEndian.Uint32(buf[index:]))
+		}
+		index = end
+	}
+	return n, nil
+}
+
+func (m *bptreeMeta) encode() []byte {
+	buf := make([]byte, bptreePageSize)
+	binary.LittleEndian.PutUint32(buf[0:], bptreeMagic)
+	binary.LittleEndian.PutUint32(buf[4:], bptreePageSize)
+	binary.LittleEndian.PutUint64(buf[8:], m.txid)
+	binary.LittleEndian.PutUint32(buf[16:], m.root)
+	binary.LittleEndian.PutUint32(buf[20:], m.pageCount)
+	binary.LittleEndian.PutUint64(buf[24:], m.count)
+	binary.LittleEndian.PutUint32(buf[32:], m.blob)
+	binary.LittleEndian.PutUint32(buf[36:], crc32.ChecksumIEEE(buf[:36]))
+	return buf
+}
+
+// decodeMeta 解码元信息页，没有写完整的页面返回 false
+func decodeMeta(buf []byte) (*bptreeMeta, bool) {
+	if binary.LittleEndian.Uint32(buf[0:]) != bptreeMagic ||
+		binary.LittleEndian.Uint32(buf[4:]) != bptreePageSize ||
+		binary.LittleEndian.Uint32(buf[36:]) != crc32.ChecksumIEEE(buf[:36]) {
+		return nil, false
+	}
+	return &bptreeMeta{
+		txid:      binary.LittleEndian.Uint64(buf[8:]),
+		root:      binary.LittleEndian.Uint32(buf[16:]),
+		pageCount: binary.LittleEndian.Uint32(buf[20:]),
+		count:     binary.LittleEndian.Uint64(buf[24:]),
+		blob:      binary.LittleEndian.Uint32(buf[32:]),
+	}, true
+}
+
+// bptreePager 管理索引文件中的页面，解码之后的页面缓存在 LRU 中
+// 修改过的页面只写入到上一次 checkpoint 之后分配的页面中，上一次 checkpoint 的数据始终完整
+type bptreePager struct {
+	file      *os.File
+	meta      bptreeMeta
+	pageCount uint32
+	free      []uint32        // 可以直接分配的空闲页面
+	pending   []uint32        // 在本次 checkpoint 之前释放的页面，上一次 checkpoint 仍然引用，提交之后才能分配
+	fresh     map[uint32]bool // 上一次 checkpoint 之后分配的页面，可以直接修改
+	blobPages []uint32        // 上一次 checkpoint 写入的 blob 页面
+	userMeta  []byte
+
+	mu       sync.Mutex // 保护缓存和 err，读操作并发加载页面时使用
+	capacity int
+	nodes    map[uint32]*list.Element
+	lru      *list.List
+	err      error // 读写文件失败之后不再接受修改
+}
+
+func openPager(path string, cacheSize int64) (*bptreePager, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	capacity := int(cacheSize / bptreePageSize)
+	if capacity < 1 {
+		capacity = 1
+	}
+	p := &bptreePager{
+		file:     file,
+		fresh:    make(map[uint32]bool),
+		capacity: capacity,
+		nodes:    make(map[uint32]*list.Element),
+		lru:      list.New(),
+	}
+
+	stat, err := file.Stat()
+	if err == nil {
+		if stat.Size() == 0 {
+			err = p.init()
+		} else {
+			err = p.load()
+		}
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// init 初始化空的索引文件，只有一个空的叶子节点作为根节点
+func (p *bptreePager) init() error {
+	root := &bptNode{id: bptreeRootPage, leaf: true}
+	if _, err := p.file.WriteAt(root.encode(), bptreeRootPage*bptreePageSize); err != nil {
+		return err
+	}
+	p.meta = bptreeMeta{txid: 1, root: bptreeRootPage, pageCount: bptreeRootPage + 1}
+	if _, err := p.file.WriteAt(p.meta.encode(), int64(p.meta.txid%bptreeRootPage)*bptreePageSize); err != nil {
+		return err
+	}
+	p.pageCount = p.meta.pageCount
+	return p.file.Sync()
+}
+
+// load 加载序号最大的有效元信息，以及空闲页面和用户元信息
+func (p *bptreePager) load() error {
+	var meta *bptreeMeta
+	buf := make([]byte, bptreePageSize)
+	for i := int64(0); i < bptreeRootPage; i++ {
+		if _, err := p.file.ReadAt(buf, i*bptreePageSize); err != nil && err != io.EOF {
+			return err
+		}
+		if m, ok := decodeMeta(buf); ok && (meta == nil || m.txid > meta.txid) {
+			meta = m
+		}
+		clear(buf)
+	}
+	if meta == nil {
+		return ErrIndexFileCorrupted
+	}
+	p.meta = *meta
+	p.pageCount = meta.pageCount
+
+	// 读取 blob 页面链表
+	var blob []byte
+	for id := meta.blob; id != 0; {
+		if id >= meta.pageCount {
+			return ErrIndexFileCorrupted
+		}
+		if _, err := p.file.ReadAt(buf, int64(id)*bptreePageSize); err != nil {
+			return err
+		}
+		length := int(binary.LittleEndian.Uint16(buf[5:]))
+		if buf[0] != bptreeBlobPage || bptreeBlobHeaderSize+length > bptreePageSize {
+			return ErrIndexFileCorrupted
+		}
+		blob = append(blob, buf[bptreeBlobHeaderSize:bptreeBlobHeaderSize+length]...)
+		p.blobPages = append(p.blobPages, id)
+		id = binary.LittleEndian.Uint32(buf[1:])
+	}
+	if meta.blob == 0 {
+		return nil
+	}
+
+	num, n := binary.Uvarint(blob)
+	if n <= 0 {
+		return ErrIndexFileCorrupted
+	}
+	index := n
+	for i := uint64(0); i < num; i++ {
+		id, n := binary.Uvarint(blob[index:])
+		if n <= 0 {
+			return ErrIndexFileCorrupted
+		}
+		p.free = append(p.free, uint32(id))
+		index += n
+	}
+	p.userMeta = blob[index:]
+	return nil
+}
+
+// node 读取页面，优先从缓存中获取
+func (p *bptreePager) node(id uint32) (*bptNode, error) {
+	p.mu.Lock()
+	if elem, ok := p.nodes[id]; ok {
+		p.lru.MoveToFront(elem)
+		p.mu.Unlock()
+		return elem.Value.(*bptNode), nil
+	}
+	p.mu.Unlock()
+
+	buf := make([]byte, bptreePageSize)
+	if _, err := p.file.ReadAt(buf, int64(id)*bptreePageSize); err != nil {
+		p.fail(err)
+		return nil, err
+	}
+	n, err := decodeNode(id, buf)
+	if err != nil {
+		p.fail(err)
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 其他读操作可能已经加载了同一个页面
+	if elem, ok := p.nodes[id]; ok {
+		return elem.Value.(*bptNode), nil
+	}
+	p.nodes[id] = p.lru.PushFront(n)
+	return n, nil
+}
+
+// add 将新的页面加入缓存
+func (p *bptreePager) add(n *bptNode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes[n.id] = p.lru.PushFront(n)
+}
+
+// remove 从缓存中删除页面
+func (p *bptreePager) remove(id uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elem, ok := p.nodes[id]; ok {
+		p.lru.Remove(elem)
+		delete(p.nodes, id)
+	}
+}
+
+// evict 缓存超过容量时淘汰最久没有使用的页面，修改过的页面先写入文件
+// 只在一次操作结束之后调用，操作过程中持有的页面不会被淘汰
+func (p *bptreePager) evict() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.lru.Len() > p.capacity {
+		elem := p.lru.Back()
+		n := elem.Value.(*bptNode)
+		if n.dirty {
+			if _, err := p.file.WriteAt(n.encode(), int64(n.id)*bptreePageSize); err != nil {
+				p.err = err
+				return
+			}
+			n.dirty = false
+		}
+		p.lru.Remove(elem)
+		delete(p.nodes, n.id)
+	}
+}
+
+// cached 缓存中的页面数量
+func (p *bptreePager) cached() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// alloc 分配一个新的页面
+func (p *bptreePager) alloc() uint32 {
+	var id uint32
+	if len(p.free) > 0 {
+		id = p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+	} else {
+		id = p.pageCount
+		p.pageCount++
+	}
+	p.fresh[id] = true
+	return id
+}
+
+// release 释放页面，上一次 checkpoint 之后分配的页面可以立即重新分配
+func (p *bptreePager) release(id uint32) {
+	p.remove(id)
+	if p.fresh[id] {
+		delete(p.fresh, id)
+		p.free = append(p.free, id)
+		return
+	}
+	p.pending = append(p.pending, id)
+}
+
+// writable 返回可以修改的页面，上一次 checkpoint 引用的页面会被复制到新的页面中，调用方需要更新父节点中的页面 id
+func (p *bptreePager) writable(n *bptNode) {
+	n.dirty = true
+	if p.fresh[n.id] {
+		return
+	}
+	p.release(n.id)
+	n.id = p.alloc()
+	p.add(n)
+}
+
+// commit 写入所有修改过的页面，再写入新的元信息，元信息写入之后本次修改才生效
+func (p *bptreePager) commit(root uint32, count int, userMeta []byte) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		n := elem.Value.(*bptNode)
+		if !n.dirty {
+			continue
+		}
+		if _, err := p.file.WriteAt(n.encode(), int64(n.id)*bptreePageSize); err != nil {
+			p.mu.Unlock()
+			p.fail(err)
+			return err
+		}
+		n.dirty = false
+	}
+	p.mu.Unlock()
+
+	// blob 页面优先从当前的空闲页面中分配，按照编码之后的最大长度预留页面
+	chunk := bptreePageSize - bptreeBlobHeaderSize
+	maxLen := binary.MaxVarintLen32*(1+len(p.free)+len(p.pending)+len(p.blobPages)) + len(userMeta)
+	blobPages := make([]uint32, (maxLen+chunk-1)/chunk)
+	for i := range blobPages {
+		if len(p.free) > 0 {
+			blobPages[i] = p.free[len(p.free)-1]
+			p.free = p.free[:len(p.free)-1]
+		} else {
+			blobPages[i] = p.pageCount
+			p.pageCount++
+		}
+	}
+
+	// 提交之后空闲的页面，包括本次释放的页面和上一次的 blob 页面
+	free := make([]uint32, 0, len(p.free)+len(p.pending)+len(p.blobPages))
+	free = append(free, p.free...)
+	free = append(free, p.pending...)
+	free = append(free, p.blobPages...)
+	blob := binary.AppendUvarint(nil, uint64(len(free)))
+	for _, id := range free {
+		blob = binary.AppendUvarint(blob, uint64(id))
+	}
+	blob = append(blob, userMeta...)
+
+	for i, id := range blobPages {
+		buf := make([]byte, bptreePageSize)
+		buf[0] = bptreeBlobPage
+		if i+1 < len(blobPages) {
+			binary.LittleEndian.PutUint32(buf[1:], blobPages[i+1])
+		}
+		n := copy(buf[bptreeBlobHeaderSize:], blob[min(i*chunk, len(blob)):])
+		binary.LittleEndian.PutUint16(buf[5:], uint16(n))
+		if _, err := p.file.WriteAt(buf, int64(id)*bptreePageSize); err != nil {
+			p.fail(err)
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		p.fail(err)
+		return err
+	}
+
+	meta := bptreeMeta{
+		txid:      p.meta.txid + 1,
+		root:      root,
+		pageCount: p.pageCount,
+		count:     uint64(count),
+		blob:      blobPages[0],
+	}
+	if _, err := p.file.WriteAt(meta.encode(), int64(meta.txid%bptreeRootPage)*bptreePageSize); err != nil {
+		p.fail(err)
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		p.fail(err)
+		return err
+	}
+
+	p.meta = meta
+	p.free = free
+	p.pending = nil
+	p.fresh = make(map[uint32]bool)
+	p.blobPages = blobPages
+	p.userMeta = userMeta
+	return nil
+}
+
+func (p *bptreePager) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *bptreePager) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *bptreePager) close() error {
+	return p.file.Close()
+}
@@ -0,0 +1,226 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+func newTestBPlusTree(t *testing.T, cacheSize int64) (*BPlusTree, string) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree")
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	path := filepath.Join(dir, "bptree-index")
+	bpt, err := NewBPlusTree(path, cacheSize)
+	assert.Nil(t, err)
+	return bpt, path
+}
+
+func TestBPlusTree_PutGetDelete(t *testing.T) {
+	bpt, _ := newTestBPlusTree(t, 1024*1024)
+	defer bpt.Close()
+
+	// 1.写入和读取
+	assert.True(t, bpt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.True(t, bpt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10}))
+	assert.Equal(t, int64(100), bpt.Get(nil).Offset)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10}, bpt.Get([]byte("a")))
+
+	// 2.覆盖
+	assert.True(t, bpt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3}))
+	assert.Equal(t, int64(3), bpt.Get([]byte("a")).Offset)
+	assert.Equal(t, 2, bpt.Size())
+
+	// 3.删除
+	assert.True(t, bpt.Delete([]byte("a")))
+	assert.False(t, bpt.Delete([]byte("a")))
+	assert.Nil(t, bpt.Get([]byte("a")))
+	assert.Equal(t, 1, bpt.Size())
+
+	// 4.key 过长
+	assert.False(t, bpt.Put(make([]byte, 2048), &data.LogRecordPos{Fid: 1}))
+}
+
+func TestBPlusTree_Iterator(t *testing.T) {
+	bpt, _ := newTestBPlusTree(t, 1024*1024)
+	defer bpt.Close()
+
+	// 1.为空
+	iter1 := bpt.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	// 2.多个叶子节点
+	for i := 0; i < 1000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	var i int
+	for iter := bpt.Iterator(false); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+		assert.Equal(t, int64(i), iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	for iter := bpt.Iterator(true); iter.Valid(); iter.Next() {
+		i--
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+	}
+	assert.Equal(t, 0, i)
+
+	// 3.Seek
+	iter2 := bpt.Iterator(false)
+	iter2.Seek([]byte("key-0500x"))
+	assert.Equal(t, "key-0501", string(iter2.Key()))
+	iter3 := bpt.Iterator(true)
+	iter3.Seek([]byte("key-0500x"))
+	assert.Equal(t, "key-0500", string(iter3.Key()))
+	iter3.Seek([]byte("a"))
+	assert.False(t, iter3.Valid())
+}
+
+// 随机写入和删除，结果与 BTree 保持一致，页面缓存很小，需要不断淘汰和重新读取页面
+func TestBPlusTree_CompareWithBTree(t *testing.T) {
+	bpt, path := newTestBPlusTree(t, 8*bptreePageSize)
+	bt := NewBTree()
+	rnd := rand.New(rand.NewSource(1))
+
+	randKey := func() []byte {
+		key := make([]byte, 1+rnd.Intn(64))
+		rnd.Read(key)
+		return key[:1+rnd.Intn(len(key))]
+	}
+	check := func() {
+		assert.Equal(t, bt.Size(), bpt.Size())
+		for _, reverse := range []bool{false, true} {
+			iter1, iter2 := bt.Iterator(reverse), bpt.Iterator(reverse)
+			for iter1.Valid() {
+				if !assert.True(t, iter2.Valid()) {
+					return
+				}
+				assert.True(t, bytes.Equal(iter1.Key(), iter2.Key()))
+				assert.Equal(t, iter1.Value(), iter2.Value())
+				iter1.Next()
+				iter2.Next()
+			}
+			assert.False(t, iter2.Valid())
+
+			seek := randKey()
+			iter1.Seek(seek)
+			iter2.Seek(seek)
+			assert.Equal(t, iter1.Valid(), iter2.Valid())
+			if iter1.Valid() {
+				assert.Equal(t, iter1.Key(), iter2.Key())
+			}
+		}
+	}
+
+	var written [][]byte
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 5000; i++ {
+			key := randKey()
+			written = append(written, key)
+			pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i), Size: uint32(len(key))}
+			bt.Put(key, pos)
+			assert.True(t, bpt.Put(key, pos))
+		}
+		for i := 0; i < 4000; i++ {
+			key := written[rnd.Intn(len(written))]
+			assert.Equal(t, bt.Delete(key), bpt.Delete(key))
+		}
+		check()
+
+		// checkpoint 之后重新打开，数据不变
+		assert.Nil(t, bpt.Checkpoint([]byte(fmt.Sprintf("round-%d", round))))
+		assert.Nil(t, bpt.Close())
+		var err error
+		bpt, err = NewBPlusTree(path, 8*bptreePageSize)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("round-%d", round), string(bpt.CheckpointMeta()))
+		check()
+	}
+
+	// 删除所有数据
+	for _, key := range written {
+		bt.Delete(key)
+		bpt.Delete(key)
+	}
+	check()
+	assert.Nil(t, bpt.Close())
+}
+
+func TestBPlusTree_RecoverFromCheckpoint(t *testing.T) {
+	bpt, path := newTestBPlusTree(t, 4*bptreePageSize)
+
+	// 1.写入数据并 checkpoint
+	for i := 0; i < 2000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, bpt.Checkpoint([]byte("cp-1")))
+
+	// 2.继续修改，页面缓存很小，修改过的页面会被写入文件，但是不会覆盖 checkpoint 的数据
+	for i := 0; i < 2000; i++ {
+		if i%2 == 0 {
+			bpt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+		} else {
+			bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		}
+	}
+	for i := 2000; i < 3000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+	}
+
+	// 3.没有 checkpoint 直接关闭，模拟崩溃，恢复到上一次 checkpoint 的状态
+	assert.Nil(t, bpt.Close())
+	bpt, err := NewBPlusTree(path, 4*bptreePageSize)
+	assert.Nil(t, err)
+	assert.Equal(t, "cp-1", string(bpt.CheckpointMeta()))
+	assert.Equal(t, 2000, bpt.Size())
+	for i := 0; i < 3000; i++ {
+		pos := bpt.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if i < 2000 {
+			assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: int64(i)}, pos)
+		} else {
+			assert.Nil(t, pos)
+		}
+	}
+
+	// 4.元信息页写入不完整时使用上一个元信息页
+	assert.True(t, bpt.Put([]byte("key-new"), &data.LogRecordPos{Fid: 3}))
+	assert.Nil(t, bpt.Checkpoint([]byte("cp-2")))
+	txid := bpt.pager.meta.txid
+	assert.Nil(t, bpt.Close())
+	file, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	_, _ = file.WriteAt([]byte("torn"), int64(txid%bptreeRootPage)*bptreePageSize+8)
+	_ = file.Close()
+	bpt, err = NewBPlusTree(path, 4*bptreePageSize)
+	assert.Nil(t, err)
+	assert.Equal(t, "cp-1", string(bpt.CheckpointMeta()))
+	assert.Nil(t, bpt.Get([]byte("key-new")))
+	assert.Nil(t, bpt.Close())
+}
+
+func TestBPlusTree_ReusePages(t *testing.T) {
+	bpt, path := newTestBPlusTree(t, 1024*1024)
+	defer bpt.Close()
+
+	// 反复写入和删除，释放的页面在 checkpoint 之后被重新使用，文件大小保持稳定
+	var sizes []int64
+	for round := 0; round < 6; round++ {
+		for i := 0; i < 2000; i++ {
+			bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: uint32(round), Offset: int64(i)})
+		}
+		for i := 0; i < 2000; i++ {
+			bpt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+		}
+		assert.Nil(t, bpt.Checkpoint(nil))
+		stat, _ := os.Stat(path)
+		sizes = append(sizes, stat.Size())
+	}
+	assert.Equal(t, sizes[2], sizes[len(sizes)-1])
+}
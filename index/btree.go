@@ -2,18 +2,22 @@ package index
 
 import (
 	"bytes"
-	"sort"
 	"sync"
+	"unsafe"
 
 	"github.com/google/btree"
 	"github.com/xavier-tse/bitcask-go/data"
 )
 
 type BTree struct {
-	tree *btree.BTree
-	lock *sync.RWMutex
+	tree     *btree.BTree
+	keyBytes int64 // 所有 key 的长度之和
+	lock     *sync.RWMutex
 }
 
+// btreeItemMemory 每个 key 除了 key 本身之外占用的内存，btree 节点中的 interface 按照 2/3 的填充率估算
+const btreeItemMemory = int64(unsafe.Sizeof(Item{})+unsafe.Sizeof(data.LogRecordPos{})) + 16*3/2
+
 func NewBTree() *BTree {
 	return &BTree{
 		tree: btree.New(32),
@@ -27,7 +31,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) bool {
 		pos: pos,
 	}
 	bt.lock.Lock()
-	bt.tree.ReplaceOrInsert(it)
+	if oldItem := bt.tree.ReplaceOrInsert(it); oldItem == nil {
+		bt.keyBytes += int64(len(key))
+	}
 	bt.lock.Unlock()
 	return true
 }
@@ -36,7 +42,9 @@ func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{
 		key: key,
 	}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -49,6 +57,9 @@ func (bt *BTree) Delete(key []byte) bool {
 	}
 	bt.lock.Lock()
 	oldItem := bt.tree.Delete(it)
+	if oldItem != nil {
+		bt.keyBytes -= int64(len(key))
+	}
 	bt.lock.Unlock()
 	if oldItem == nil {
 		return false
@@ -60,77 +71,120 @@ func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+func (bt *BTree) MemoryUsage() int64 {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return int64(bt.tree.Len())*btreeItemMemory + bt.keyBytes
+}
+
+// Iterator 创建时复制一个共享节点的快照，不会复制所有数据
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// Clone 会修改原来的树的写时复制标记，需要持有写锁
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return newBTreeIterator(bt.tree.Clone(), reverse)
 }
 
-// BTree 索引迭代器
+// btreeIteratorBatch 迭代器每次从快照中读取的数据量
+const btreeIteratorBatch = 64
+
+// BTree 索引迭代器，遍历创建时的快照，每次按需从快照中读取一批数据
 type btreeIterator struct {
-	currIndex int     // 当前遍历的下标位置
-	reverse   bool    // 是否反向遍历
-	values    []*Item // key+位置索引信息
+	tree     *btree.BTree // 创建迭代器时的快照，和原来的树共享节点，写入时才复制
+	reverse  bool         // 是否反向遍历
+	items    []*Item      // 当前读取的一批数据
+	currIdx  int          // 当前遍历的下标位置
+	loaded   bool         // 是否已经读取了当前位置的数据
+	pivot    []byte       // 下一批数据的起点，为 nil 并且 fromEdge 为 true 时从头开始
+	fromEdge bool
+	exclude  bool // 下一批数据是否跳过 pivot 本身
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	// 将所有数据存放到数组中
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
-	}
-
 	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+		tree:     tree,
+		reverse:  reverse,
+		fromEdge: true,
 	}
 }
 
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	bti.reset(nil, true)
 }
 
 func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
+	bti.reset(key, false)
 }
 
 func (bti *btreeIterator) Next() {
-	bti.currIndex++
+	bti.load()
+	if bti.currIdx >= len(bti.items) {
+		return
+	}
+	bti.currIdx++
+	// 当前一批数据已经读完，并且快照中可能还有数据，从最后一个 key 之后继续读取
+	if bti.currIdx == len(bti.items) && len(bti.items) == btreeIteratorBatch {
+		last := bti.items[len(bti.items)-1].key
+		bti.reset(last, false)
+		bti.exclude = true
+	}
 }
 
 func (bti *btreeIterator) Valid() bool {
-	return bti.currIndex < len(bti.values)
+	bti.load()
+	return bti.currIdx < len(bti.items)
 }
 
 func (bti *btreeIterator) Key() []byte {
-	return bti.values[bti.currIndex].key
+	bti.load()
+	return bti.items[bti.currIdx].key
 }
 
 func (bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.currIndex].pos
+	bti.load()
+	return bti.items[bti.currIdx].pos
 }
 
 func (bti *btreeIterator) Close() {
-	bti.values = nil
+	bti.tree = nil
+	bti.items = nil
+	bti.loaded = true
+}
+
+// reset 移动到新的起点，数据在第一次访问时才读取
+func (bti *btreeIterator) reset(pivot []byte, fromEdge bool) {
+	bti.pivot, bti.fromEdge, bti.exclude = pivot, fromEdge, false
+	bti.loaded = false
+}
+
+// load 从快照中读取一批数据
+func (bti *btreeIterator) load() {
+	if bti.loaded {
+		return
+	}
+	bti.loaded = true
+	bti.items = bti.items[:0]
+	bti.currIdx = 0
+
+	collect := func(it btree.Item) bool {
+		item := it.(*Item)
+		if bti.exclude && bytes.Equal(item.key, bti.pivot) {
+			return true
+		}
+		bti.items = append(bti.items, item)
+		return len(bti.items) < btreeIteratorBatch
+	}
+	switch {
+	case bti.fromEdge && bti.reverse:
+		bti.tree.Descend(collect)
+	case bti.fromEdge:
+		bti.tree.Ascend(collect)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(&Item{key: bti.pivot}, collect)
+	default:
+		bti.tree.AscendGreaterOrEqual(&Item{key: bti.pivot}, collect)
+	}
 }
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -125,3 +126,49 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_IteratorSnapshot(t *testing.T) {
+	bt := NewBTree()
+	for i := 0; i < 1000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 1.创建迭代器之后的修改不影响迭代器
+	iter := bt.Iterator(false)
+	for i := 0; i < 1000; i += 2 {
+		bt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	bt.Put([]byte("key-0001"), &data.LogRecordPos{Fid: 2, Offset: 1})
+	bt.Put([]byte("key-new"), &data.LogRecordPos{Fid: 2})
+	var i int
+	for ; iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+		assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: int64(i)}, iter.Value())
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	assert.Equal(t, uint32(2), bt.Get([]byte("key-0001")).Fid)
+	assert.Equal(t, 501, bt.Size())
+
+	// 2.跨越多个批次反向遍历
+	iter.Close()
+	iter = bt.Iterator(true)
+	assert.Equal(t, "key-new", string(iter.Key()))
+	i = 999
+	for iter.Next(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+		i -= 2
+	}
+	assert.Equal(t, -1, i)
+
+	// 3.Seek 和 Rewind
+	iter.Seek([]byte("key-0500"))
+	assert.Equal(t, "key-0499", string(iter.Key()))
+	iter.Rewind()
+	assert.Equal(t, "key-new", string(iter.Key()))
+	iter = bt.Iterator(false)
+	iter.Seek([]byte("key-0500"))
+	assert.Equal(t, "key-0501", string(iter.Key()))
+	iter.Seek([]byte("key-z"))
+	assert.False(t, iter.Valid())
+}
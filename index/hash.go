@@ -0,0 +1,222 @@
+package index
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+const (
+	hashSlotEmpty     uint32 = 0 // 空槽位，查找时遇到说明 key 不存在
+	hashSlotTombstone uint32 = 1 // 被删除的槽位，查找时需要跳过
+
+	hashInitSlots       = 64
+	hashMinArenaGarbage = 64 * 1024 // 被删除的 key 占用的空间超过这个值并且超过一半时才整理
+)
+
+// HashIndex 开放寻址的哈希索引，只适合点查询
+// 槽位中直接存储位置信息，key 统一存储在 arena 中，每个 key 不需要单独分配 *Item 和 *data.LogRecordPos，
+// 槽位中没有指针，GC 不需要扫描。遍历时需要复制并排序所有 key，复杂度为 O(n log n)
+type HashIndex struct {
+	slots   []hashSlot // 长度为 2 的幂，使用线性探测
+	arena   []byte     // 所有 key 依次存储，只追加，不修改已经写入的部分
+	count   int        // 有效的 key 数量
+	used    int        // 有效的和被删除的槽位数量
+	garbage int        // arena 中被删除的 key 占用的空间
+	lock    *sync.RWMutex
+}
+
+// hashSlot 哈希槽位，hash 小于 2 时表示空槽位或者被删除的槽位
+type hashSlot struct {
+	keyOff int64
+	keyLen uint32
+	hash   uint32
+	offset int64
+	fid    uint32
+	size   uint32
+}
+
+func NewHashIndex() *HashIndex {
+	return &HashIndex{
+		slots: make([]hashSlot, hashInitSlots),
+		lock:  new(sync.RWMutex),
+	}
+}
+
+func (hi *HashIndex) Put(key []byte, pos *data.LogRecordPos) bool {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+
+	hash := hashKey(key)
+	mask := uint32(len(hi.slots) - 1)
+	insert := -1
+	for i := hash & mask; ; i = (i + 1) & mask {
+		slot := &hi.slots[i]
+		if slot.hash == hashSlotEmpty {
+			if insert < 0 {
+				insert = int(i)
+			}
+			break
+		}
+		if slot.hash == hashSlotTombstone {
+			if insert < 0 {
+				insert = int(i)
+			}
+			continue
+		}
+		if slot.hash == hash && bytes.Equal(hi.key(slot), key) {
+			slot.setPos(pos)
+			return true
+		}
+	}
+
+	slot := &hi.slots[insert]
+	if slot.hash == hashSlotEmpty {
+		hi.used++
+	}
+	*slot = hashSlot{
+		keyOff: int64(len(hi.arena)),
+		keyLen: uint32(len(key)),
+		hash:   hash,
+	}
+	slot.setPos(pos)
+	hi.arena = append(hi.arena, key...)
+	hi.count++
+
+	// 负载因子超过 3/4 时扩容，被删除的槽位和 key 过多时原地整理
+	if hi.used*4 > len(hi.slots)*3 {
+		size := len(hi.slots)
+		if hi.count*2 > size {
+			size *= 2
+		}
+		hi.rehash(size)
+	} else if hi.garbage > hashMinArenaGarbage && hi.garbage*2 > len(hi.arena) {
+		hi.rehash(len(hi.slots))
+	}
+	return true
+}
+
+func (hi *HashIndex) Get(key []byte) *data.LogRecordPos {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	if slot := hi.find(key); slot != nil {
+		return &data.LogRecordPos{Fid: slot.fid, Offset: slot.offset, Size: slot.size}
+	}
+	return nil
+}
+
+func (hi *HashIndex) Delete(key []byte) bool {
+	hi.lock.Lock()
+	defer hi.lock.Unlock()
+	slot := hi.find(key)
+	if slot == nil {
+		return false
+	}
+	hi.garbage += int(slot.keyLen)
+	*slot = hashSlot{hash: hashSlotTombstone}
+	hi.count--
+	return true
+}
+
+func (hi *HashIndex) Size() int {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return hi.count
+}
+
+// MemoryUsage 包括空槽位和 arena 中被删除的 key 占用的空间
+func (hi *HashIndex) MemoryUsage() int64 {
+	hi.lock.RLock()
+	defer hi.lock.RUnlock()
+	return int64(len(hi.slots))*int64(unsafe.Sizeof(hashSlot{})) + int64(cap(hi.arena))
+}
+
+// Iterator 哈希索引没有顺序，创建时复制所有 key 并排序
+func (hi *HashIndex) Iterator(reverse bool) Iterator {
+	hi.lock.RLock()
+	values := make([]*Item, 0, hi.count)
+	for i := range hi.slots {
+		slot := &hi.slots[i]
+		if slot.hash == hashSlotEmpty || slot.hash == hashSlotTombstone {
+			continue
+		}
+		values = append(values, &Item{
+			key: hi.key(slot),
+			pos: &data.LogRecordPos{Fid: slot.fid, Offset: slot.offset, Size: slot.size},
+		})
+	}
+	hi.lock.RUnlock()
+
+	sort.Slice(values, func(i, j int) bool {
+		cmp := bytes.Compare(values[i].key, values[j].key)
+		if reverse {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	return newSliceIterator(values, reverse)
+}
+
+// find 查找 key 所在的槽位，不存在时返回 nil
+func (hi *HashIndex) find(key []byte) *hashSlot {
+	hash := hashKey(key)
+	mask := uint32(len(hi.slots) - 1)
+	for i := hash & mask; ; i = (i + 1) & mask {
+		slot := &hi.slots[i]
+		if slot.hash == hashSlotEmpty {
+			return nil
+		}
+		if slot.hash == hash && bytes.Equal(hi.key(slot), key) {
+			return slot
+		}
+	}
+}
+
+// key 返回槽位对应的 key，arena 只追加并且整理时会重新分配，返回的数据之后不会被修改
+func (hi *HashIndex) key(slot *hashSlot) []byte {
+	end := slot.keyOff + int64(slot.keyLen)
+	return hi.arena[slot.keyOff:end:end]
+}
+
+// rehash 将有效的数据重新放入 size 个槽位中，同时整理 arena，去掉被删除的 key
+func (hi *HashIndex) rehash(size int) {
+	slots := make([]hashSlot, size)
+	arena := make([]byte, 0, len(hi.arena)-hi.garbage)
+	mask := uint32(size - 1)
+	for _, slot := range hi.slots {
+		if slot.hash == hashSlotEmpty || slot.hash == hashSlotTombstone {
+			continue
+		}
+		key := hi.key(&slot)
+		slot.keyOff = int64(len(arena))
+		arena = append(arena, key...)
+
+		i := slot.hash & mask
+		for slots[i].hash != hashSlotEmpty {
+			i = (i + 1) & mask
+		}
+		slots[i] = slot
+	}
+	hi.slots, hi.arena = slots, arena
+	hi.used, hi.garbage = hi.count, 0
+}
+
+func (slot *hashSlot) setPos(pos *data.LogRecordPos) {
+	slot.fid, slot.offset, slot.size = pos.Fid, pos.Offset, pos.Size
+}
+
+// hashKey 计算 key 的 FNV-1a 哈希值，避开表示空槽位和被删除槽位的值
+func hashKey(key []byte) uint32 {
+	hash := uint32(2166136261)
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	if hash <= hashSlotTombstone {
+		hash += 2
+	}
+	return hash
+}
@@ -0,0 +1,117 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+func TestHashIndex_PutGetDelete(t *testing.T) {
+	hi := NewHashIndex()
+
+	// 1.写入和覆盖
+	assert.True(t, hi.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.True(t, hi.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10}))
+	assert.True(t, hi.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3, Size: 11}))
+	assert.Equal(t, int64(100), hi.Get(nil).Offset)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 3, Size: 11}, hi.Get([]byte("a")))
+	assert.Nil(t, hi.Get([]byte("b")))
+	assert.Equal(t, 2, hi.Size())
+
+	// 2.删除之后重新写入
+	assert.True(t, hi.Delete([]byte("a")))
+	assert.False(t, hi.Delete([]byte("a")))
+	assert.Nil(t, hi.Get([]byte("a")))
+	assert.Equal(t, 1, hi.Size())
+	assert.True(t, hi.Put([]byte("a"), &data.LogRecordPos{Fid: 2}))
+	assert.Equal(t, uint32(2), hi.Get([]byte("a")).Fid)
+	assert.Equal(t, 2, hi.Size())
+}
+
+func TestHashIndex_Iterator(t *testing.T) {
+	hi := NewHashIndex()
+	// 1.为空
+	iter1 := hi.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	// 2.遍历时按照 key 排序
+	for i := 99; i >= 0; i-- {
+		hi.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	var i int
+	for iter := hi.Iterator(false); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+		assert.Equal(t, int64(i), iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	for iter := hi.Iterator(true); iter.Valid(); iter.Next() {
+		i--
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+	}
+	assert.Equal(t, 0, i)
+
+	// 3.Seek
+	iter2 := hi.Iterator(false)
+	iter2.Seek([]byte("key-050x"))
+	assert.Equal(t, "key-051", string(iter2.Key()))
+	iter3 := hi.Iterator(true)
+	iter3.Seek([]byte("key-050x"))
+	assert.Equal(t, "key-050", string(iter3.Key()))
+
+	// 4.创建迭代器之后的修改不影响迭代器
+	iter4 := hi.Iterator(false)
+	for i := 0; i < 100; i++ {
+		hi.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+	assert.Equal(t, "key-000", string(iter4.Key()))
+}
+
+// 随机写入和删除，结果与 BTree 保持一致，覆盖扩容和整理被删除的 key
+func TestHashIndex_CompareWithBTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hi, bt := NewHashIndex(), NewBTree()
+
+	randKey := func() []byte {
+		key := make([]byte, rnd.Intn(64))
+		rnd.Read(key)
+		return key[:rnd.Intn(len(key)+1)]
+	}
+	check := func() {
+		assert.Equal(t, bt.Size(), hi.Size())
+		iter1, iter2 := bt.Iterator(false), hi.Iterator(false)
+		for iter1.Valid() {
+			if !assert.True(t, iter2.Valid()) {
+				return
+			}
+			assert.True(t, bytes.Equal(iter1.Key(), iter2.Key()))
+			assert.Equal(t, iter1.Value(), iter2.Value())
+			iter1.Next()
+			iter2.Next()
+		}
+		assert.False(t, iter2.Valid())
+	}
+
+	var written [][]byte
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 5000; i++ {
+			key := randKey()
+			written = append(written, key)
+			pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i), Size: uint32(len(key))}
+			bt.Put(key, pos)
+			hi.Put(key, pos)
+			assert.Equal(t, bt.Get(key), hi.Get(key))
+		}
+		for i := 0; i < 4500; i++ {
+			key := written[rnd.Intn(len(written))]
+			assert.Equal(t, bt.Delete(key), hi.Delete(key))
+			assert.Nil(t, hi.Get(key))
+		}
+		check()
+	}
+	assert.True(t, hi.garbage*2 <= len(hi.arena) || hi.garbage <= hashMinArenaGarbage)
+}
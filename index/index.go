@@ -2,6 +2,7 @@ package index
 
 import (
 	"bytes"
+	"unsafe"
 
 	"github.com/google/btree"
 	"github.com/xavier-tse/bitcask-go/data"
@@ -20,6 +21,9 @@ type Indexer interface {
 	// Size 索引中的数据量
 	Size() int
 
+	// MemoryUsage 索引占用内存的估计值，单位为字节，包括 key、位置信息和数据结构本身的开销
+	MemoryUsage() int64
+
 	// Iterator 索引迭代器
 	Iterator(reverse bool) Iterator
 }
@@ -29,6 +33,18 @@ type IndexType = int8
 const (
 	// Btree 索引
 	Btree IndexType = iota + 1
+
+	// ART 自适应基数树索引
+	ART
+
+	// BPTree 磁盘 B+ 树索引，需要通过 NewBPlusTree 打开索引文件
+	BPTree
+
+	// SkipList 跳表索引，读取不需要加锁
+	SkipList
+
+	// Hash 哈希索引，只适合点查询，遍历时需要排序所有 key
+	Hash
 )
 
 // NewIndexer 根据 indexType 初始化索引
@@ -36,11 +52,35 @@ func NewIndexer(typ IndexType) Indexer {
 	switch typ {
 	case Btree:
 		return NewBTree()
+	case ART:
+		return NewAdaptiveRadixTree()
+	case SkipList:
+		return NewConcurrentSkipList()
+	case Hash:
+		return NewHashIndex()
 	default:
 		panic("unsupported index type")
 	}
 }
 
+// EstimateKeyMemory 估计 typ 类型的索引写入一个新的 key 之后增加的内存，用于写入之前检查内存上限
+// 结果只是近似值，磁盘 B+ 树索引只计算页面缓存，返回 0
+func EstimateKeyMemory(typ IndexType, key []byte) int64 {
+	switch typ {
+	case Btree:
+		return btreeItemMemory + int64(len(key))
+	case ART:
+		return artNodeMemory + int64(len(key))
+	case SkipList:
+		return skipListNodeMemory(key, 1)
+	case Hash:
+		// 槽位的填充率在 3/8 到 3/4 之间，按照每个 key 两个槽位估算
+		return 2*int64(unsafe.Sizeof(hashSlot{})) + int64(len(key))
+	default:
+		return 0
+	}
+}
+
 type Item struct {
 	key []byte
 	pos *data.LogRecordPos
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+func TestIndexer_MemoryUsage(t *testing.T) {
+	indexers := map[string]Indexer{
+		"btree":    NewBTree(),
+		"art":      NewAdaptiveRadixTree(),
+		"skiplist": NewConcurrentSkipList(),
+		"hash":     NewHashIndex(),
+		"sharded":  NewShardedIndex(Btree, 4),
+	}
+	for name, idx := range indexers {
+		// 1.写入新的 key 时增加
+		empty := idx.MemoryUsage()
+		for i := 0; i < 1000; i++ {
+			idx.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		full := idx.MemoryUsage()
+		assert.True(t, full > empty+1000*8, name)
+
+		// 2.覆盖已有的 key 时不变
+		for i := 0; i < 1000; i++ {
+			idx.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		}
+		assert.Equal(t, full, idx.MemoryUsage(), name)
+
+		// 3.删除之后不会增加，哈希索引的槽位不会缩容
+		for i := 0; i < 1000; i++ {
+			idx.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+		}
+		if _, ok := idx.(*HashIndex); ok {
+			assert.True(t, idx.MemoryUsage() <= full, name)
+		} else {
+			assert.Equal(t, empty, idx.MemoryUsage(), name)
+		}
+	}
+
+	// 4.B+ 树索引只计算页面缓存
+	dir, _ := os.MkdirTemp("", "bitcask-go-memory")
+	defer os.RemoveAll(dir)
+	bpt, err := NewBPlusTree(filepath.Join(dir, "bptree-index"), 8*bptreePageSize)
+	assert.Nil(t, err)
+	defer bpt.Close()
+	for i := 0; i < 10000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.True(t, bpt.MemoryUsage() > 0)
+	assert.True(t, bpt.MemoryUsage() <= 8*bptreePageSize)
+}
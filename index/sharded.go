@@ -0,0 +1,163 @@
+package index
+
+import (
+	"bytes"
+	"container/heap"
+	"hash/fnv"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// ShardedIndex 按照 key 的哈希值将数据分散到多个索引中，每个分片有独立的锁，减少并发写入时的锁竞争
+type ShardedIndex struct {
+	shards []Indexer
+}
+
+// NewShardedIndex 创建 n 个 typ 类型的分片
+func NewShardedIndex(typ IndexType, n int) *ShardedIndex {
+	shards := make([]Indexer, n)
+	for i := range shards {
+		shards[i] = NewIndexer(typ)
+	}
+	return &ShardedIndex{shards: shards}
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) bool {
+	return si.shard(key).Put(key, pos)
+}
+
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) bool {
+	return si.shard(key).Delete(key)
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+func (si *ShardedIndex) MemoryUsage() int64 {
+	var usage int64
+	for _, shard := range si.shards {
+		usage += shard.MemoryUsage()
+	}
+	return usage
+}
+
+// Iterator 对所有分片的迭代器做多路归并，按照 key 的顺序遍历
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iters[i] = shard.Iterator(reverse)
+	}
+	mi := &mergeIterator{
+		iters:   iters,
+		reverse: reverse,
+	}
+	mi.init()
+	return mi
+}
+
+func (si *ShardedIndex) shard(key []byte) Indexer {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return si.shards[h.Sum32()%uint32(len(si.shards))]
+}
+
+// mergeIterator 多路归并迭代器，堆顶为当前 key 最小（反向遍历时最大）的迭代器，各个迭代器中的 key 不重复
+type mergeIterator struct {
+	iters   []Iterator
+	reverse bool
+	valid   []int // 还有数据的迭代器下标，按照堆的顺序排列
+}
+
+func (mi *mergeIterator) Rewind() {
+	for _, iter := range mi.iters {
+		iter.Rewind()
+	}
+	mi.init()
+}
+
+func (mi *mergeIterator) Seek(key []byte) {
+	for _, iter := range mi.iters {
+		iter.Seek(key)
+	}
+	mi.init()
+}
+
+func (mi *mergeIterator) Next() {
+	if len(mi.valid) == 0 {
+		return
+	}
+	iter := mi.iters[mi.valid[0]]
+	iter.Next()
+	if iter.Valid() {
+		heap.Fix(mi, 0)
+	} else {
+		heap.Pop(mi)
+	}
+}
+
+func (mi *mergeIterator) Valid() bool {
+	return len(mi.valid) > 0
+}
+
+func (mi *mergeIterator) Key() []byte {
+	return mi.iters[mi.valid[0]].Key()
+}
+
+func (mi *mergeIterator) Value() *data.LogRecordPos {
+	return mi.iters[mi.valid[0]].Value()
+}
+
+func (mi *mergeIterator) Close() {
+	for _, iter := range mi.iters {
+		iter.Close()
+	}
+	mi.valid = nil
+}
+
+// init 根据各个迭代器的当前位置重建堆
+func (mi *mergeIterator) init() {
+	mi.valid = mi.valid[:0]
+	for i, iter := range mi.iters {
+		if iter.Valid() {
+			mi.valid = append(mi.valid, i)
+		}
+	}
+	heap.Init(mi)
+}
+
+// 以下方法实现 heap.Interface
+
+func (mi *mergeIterator) Len() int {
+	return len(mi.valid)
+}
+
+func (mi *mergeIterator) Less(i, j int) bool {
+	cmp := bytes.Compare(mi.iters[mi.valid[i]].Key(), mi.iters[mi.valid[j]].Key())
+	if mi.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (mi *mergeIterator) Swap(i, j int) {
+	mi.valid[i], mi.valid[j] = mi.valid[j], mi.valid[i]
+}
+
+func (mi *mergeIterator) Push(x any) {
+	mi.valid = append(mi.valid, x.(int))
+}
+
+func (mi *mergeIterator) Pop() any {
+	last := mi.valid[len(mi.valid)-1]
+	mi.valid = mi.valid[:len(mi.valid)-1]
+	return last
+}
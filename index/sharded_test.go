@@ -0,0 +1,108 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	si := NewShardedIndex(Btree, 8)
+
+	assert.True(t, si.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.True(t, si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2}))
+	assert.True(t, si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3}))
+	assert.Equal(t, int64(100), si.Get(nil).Offset)
+	assert.Equal(t, int64(3), si.Get([]byte("a")).Offset)
+	assert.Equal(t, 2, si.Size())
+
+	assert.True(t, si.Delete([]byte("a")))
+	assert.False(t, si.Delete([]byte("a")))
+	assert.Nil(t, si.Get([]byte("a")))
+	assert.Equal(t, 1, si.Size())
+}
+
+func TestShardedIndex_Iterator(t *testing.T) {
+	si := NewShardedIndex(Btree, 8)
+	bt := NewBTree()
+	// 1.为空
+	iter1 := si.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	// 2.多路归并的结果与单个 BTree 一致
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", rnd.Intn(5000)))
+		pos := &data.LogRecordPos{Fid: 1, Offset: int64(i)}
+		si.Put(key, pos)
+		bt.Put(key, pos)
+	}
+	for _, reverse := range []bool{false, true} {
+		iter2, iter3 := si.Iterator(reverse), bt.Iterator(reverse)
+		count := 0
+		for ; iter3.Valid(); iter3.Next() {
+			assert.True(t, iter2.Valid())
+			assert.True(t, bytes.Equal(iter3.Key(), iter2.Key()))
+			assert.Equal(t, iter3.Value(), iter2.Value())
+			iter2.Next()
+			count++
+		}
+		assert.False(t, iter2.Valid())
+		assert.Equal(t, si.Size(), count)
+
+		// 3.Seek 和 Rewind
+		iter2.Seek([]byte("key-25"))
+		iter3.Seek([]byte("key-25"))
+		assert.Equal(t, iter3.Key(), iter2.Key())
+		iter2.Rewind()
+		iter3.Rewind()
+		assert.Equal(t, iter3.Key(), iter2.Key())
+		iter2.Close()
+	}
+}
+
+func TestShardedIndex_Concurrent(t *testing.T) {
+	si := NewShardedIndex(Btree, 16)
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", g, i))
+				si.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.Equal(t, int64(i), si.Get(key).Offset)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, si.Size())
+}
+
+// 多个 goroutine 并发写入，和单个 BTree 对比锁竞争的影响
+func BenchmarkShardedIndex_ParallelPut(b *testing.B) {
+	newIndexers := map[string]func() Indexer{
+		"btree":      func() Indexer { return NewBTree() },
+		"sharded-8":  func() Indexer { return NewShardedIndex(Btree, 8) },
+		"sharded-32": func() Indexer { return NewShardedIndex(Btree, 32) },
+	}
+	for _, name := range []string{"btree", "sharded-8", "sharded-32"} {
+		b.Run(name, func(b *testing.B) {
+			idx := newIndexers[name]()
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+			var seq atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					idx.Put([]byte(fmt.Sprintf("key-%09d", seq.Add(1))), pos)
+				}
+			})
+		})
+	}
+}
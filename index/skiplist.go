@@ -0,0 +1,270 @@
+package index
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+const (
+	skipListMaxLevel = 20 // 每一层的概率为 1/4，足够支撑 4^20 个 key
+	skipListBranch   = 4
+)
+
+// ConcurrentSkipList 跳表索引，读取不需要加锁，写入之间使用互斥锁串行执行
+// 写入时先设置新节点的后继再发布到前驱节点，删除时只修改前驱节点，不修改被删除节点的后继，
+// 所以并发读取时即使停留在刚被删除的节点上，也可以沿着后继继续遍历
+type ConcurrentSkipList struct {
+	head   *skipListNode
+	level  atomic.Int32
+	size   atomic.Int64
+	memory atomic.Int64
+	lock   *sync.Mutex // 只用于串行化写入
+	rnd    *rand.Rand  // 只在持有 lock 时使用
+}
+
+// skipListNode 跳表节点，pos 为空表示节点已经被删除
+type skipListNode struct {
+	key  []byte
+	pos  atomic.Pointer[data.LogRecordPos]
+	next []atomic.Pointer[skipListNode]
+}
+
+func NewConcurrentSkipList() *ConcurrentSkipList {
+	sl := &ConcurrentSkipList{
+		head: &skipListNode{next: make([]atomic.Pointer[skipListNode], skipListMaxLevel)},
+		lock: new(sync.Mutex),
+		rnd:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	sl.level.Store(1)
+	sl.memory.Store(skipListNodeMemory(nil, skipListMaxLevel))
+	return sl
+}
+
+func (sl *ConcurrentSkipList) Put(key []byte, pos *data.LogRecordPos) bool {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var preds [skipListMaxLevel]*skipListNode
+	node := sl.findPreds(key, &preds)
+	if node != nil && bytes.Equal(node.key, key) {
+		node.pos.Store(pos)
+		return true
+	}
+
+	height := sl.randomLevel()
+	level := int(sl.level.Load())
+	for i := level; i < height; i++ {
+		preds[i] = sl.head
+	}
+	node = &skipListNode{
+		key:  key,
+		next: make([]atomic.Pointer[skipListNode], height),
+	}
+	node.pos.Store(pos)
+	for i := 0; i < height; i++ {
+		node.next[i].Store(preds[i].next[i].Load())
+	}
+	// 从底层开始发布，读取时一旦能在上层看到节点，下层一定也能看到
+	for i := 0; i < height; i++ {
+		preds[i].next[i].Store(node)
+	}
+	if height > level {
+		sl.level.Store(int32(height))
+	}
+	sl.size.Add(1)
+	sl.memory.Add(skipListNodeMemory(key, height))
+	return true
+}
+
+func (sl *ConcurrentSkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.seekGE(key)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *ConcurrentSkipList) Delete(key []byte) bool {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var preds [skipListMaxLevel]*skipListNode
+	node := sl.findPreds(key, &preds)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return false
+	}
+	// 从上层开始摘除，保证节点在上层不可见时下层仍然可以找到
+	for i := len(node.next) - 1; i >= 0; i-- {
+		preds[i].next[i].Store(node.next[i].Load())
+	}
+	node.pos.Store(nil)
+	sl.size.Add(-1)
+	sl.memory.Add(-skipListNodeMemory(node.key, len(node.next)))
+	return true
+}
+
+func (sl *ConcurrentSkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+func (sl *ConcurrentSkipList) MemoryUsage() int64 {
+	return sl.memory.Load()
+}
+
+// Iterator 迭代器不复制数据，遍历时直接读取跳表，可以看到部分创建之后的修改
+func (sl *ConcurrentSkipList) Iterator(reverse bool) Iterator {
+	iter := &skipListIterator{
+		list:    sl,
+		reverse: reverse,
+	}
+	iter.Rewind()
+	return iter
+}
+
+// findPreds 找到每一层中最后一个小于 key 的节点，返回第 0 层的下一个节点，调用时必须持有写锁
+func (sl *ConcurrentSkipList) findPreds(key []byte, preds *[skipListMaxLevel]*skipListNode) *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil && bytes.Compare(next.key, key) < 0; next = x.next[i].Load() {
+			x = next
+		}
+		preds[i] = x
+	}
+	return x.next[0].Load()
+}
+
+// seekGE 返回第一个大于等于 key 的节点，可能已经被删除
+func (sl *ConcurrentSkipList) seekGE(key []byte) *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil && bytes.Compare(next.key, key) < 0; next = x.next[i].Load() {
+			x = next
+		}
+	}
+	return x.next[0].Load()
+}
+
+// seekLE 返回最后一个小于（inclusive 时小于等于）key 的节点，可能已经被删除
+func (sl *ConcurrentSkipList) seekLE(key []byte, inclusive bool) *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			cmp := bytes.Compare(next.key, key)
+			if cmp > 0 || (cmp == 0 && !inclusive) {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// last 返回最后一个节点，可能已经被删除
+func (sl *ConcurrentSkipList) last() *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// skipListNodeMemory 高度为 height 的节点占用的内存
+func skipListNodeMemory(key []byte, height int) int64 {
+	return int64(unsafe.Sizeof(skipListNode{})+unsafe.Sizeof(data.LogRecordPos{})) +
+		int64(height)*int64(unsafe.Sizeof(atomic.Pointer[skipListNode]{})) + int64(len(key))
+}
+
+func (sl *ConcurrentSkipList) randomLevel() int {
+	height := 1
+	for height < skipListMaxLevel && sl.rnd.Intn(skipListBranch) == 0 {
+		height++
+	}
+	return height
+}
+
+// skipListIterator 跳表索引迭代器，定位时记录当前节点的位置信息，节点之后被删除也不影响读取
+type skipListIterator struct {
+	list    *ConcurrentSkipList
+	reverse bool
+	curr    *skipListNode
+	pos     *data.LogRecordPos
+}
+
+func (sli *skipListIterator) Rewind() {
+	if sli.reverse {
+		sli.moveBackward(sli.list.last())
+	} else {
+		sli.moveForward(sli.list.head.next[0].Load())
+	}
+}
+
+func (sli *skipListIterator) Seek(key []byte) {
+	if sli.reverse {
+		sli.moveBackward(sli.list.seekLE(key, true))
+	} else {
+		sli.moveForward(sli.list.seekGE(key))
+	}
+}
+
+func (sli *skipListIterator) Next() {
+	if sli.curr == nil {
+		return
+	}
+	if sli.reverse {
+		sli.moveBackward(sli.list.seekLE(sli.curr.key, false))
+	} else {
+		sli.moveForward(sli.curr.next[0].Load())
+	}
+}
+
+func (sli *skipListIterator) Valid() bool {
+	return sli.curr != nil
+}
+
+func (sli *skipListIterator) Key() []byte {
+	return sli.curr.key
+}
+
+func (sli *skipListIterator) Value() *data.LogRecordPos {
+	return sli.pos
+}
+
+func (sli *skipListIterator) Close() {
+	sli.curr, sli.pos = nil, nil
+}
+
+// moveForward 从 node 开始向后找到第一个没有被删除的节点
+func (sli *skipListIterator) moveForward(node *skipListNode) {
+	for ; node != nil; node = node.next[0].Load() {
+		if pos := node.pos.Load(); pos != nil {
+			sli.curr, sli.pos = node, pos
+			return
+		}
+	}
+	sli.curr, sli.pos = nil, nil
+}
+
+// moveBackward 从 node 开始向前找到第一个没有被删除的节点
+func (sli *skipListIterator) moveBackward(node *skipListNode) {
+	for ; node != nil; node = sli.list.seekLE(node.key, false) {
+		if pos := node.pos.Load(); pos != nil {
+			sli.curr, sli.pos = node, pos
+			return
+		}
+	}
+	sli.curr, sli.pos = nil, nil
+}
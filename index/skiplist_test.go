@@ -0,0 +1,182 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+func TestSkipList_PutGetDelete(t *testing.T) {
+	sl := NewConcurrentSkipList()
+
+	// 1.写入和覆盖
+	assert.True(t, sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.True(t, sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2}))
+	assert.True(t, sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3}))
+	assert.Equal(t, int64(100), sl.Get(nil).Offset)
+	assert.Equal(t, int64(3), sl.Get([]byte("a")).Offset)
+	assert.Nil(t, sl.Get([]byte("b")))
+	assert.Equal(t, 2, sl.Size())
+
+	// 2.删除
+	assert.True(t, sl.Delete([]byte("a")))
+	assert.False(t, sl.Delete([]byte("a")))
+	assert.Nil(t, sl.Get([]byte("a")))
+	assert.Equal(t, 1, sl.Size())
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewConcurrentSkipList()
+	// 1.为空
+	iter1 := sl.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1 = sl.Iterator(true)
+	assert.False(t, iter1.Valid())
+
+	// 2.有数据
+	for i := 0; i < 100; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	var i int
+	for iter := sl.Iterator(false); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+		assert.Equal(t, int64(i), iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	for iter := sl.Iterator(true); iter.Valid(); iter.Next() {
+		i--
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+	}
+	assert.Equal(t, 0, i)
+
+	// 3.Seek
+	iter2 := sl.Iterator(false)
+	iter2.Seek([]byte("key-050x"))
+	assert.Equal(t, "key-051", string(iter2.Key()))
+	iter3 := sl.Iterator(true)
+	iter3.Seek([]byte("key-050x"))
+	assert.Equal(t, "key-050", string(iter3.Key()))
+	iter3.Seek([]byte("a"))
+	assert.False(t, iter3.Valid())
+
+	// 4.迭代器停留的节点被删除之后仍然可以继续遍历
+	iter4 := sl.Iterator(false)
+	iter4.Seek([]byte("key-010"))
+	for i := 10; i < 20; i++ {
+		sl.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+	assert.Equal(t, "key-010", string(iter4.Key()))
+	assert.Equal(t, int64(10), iter4.Value().Offset)
+	iter4.Next()
+	assert.Equal(t, "key-020", string(iter4.Key()))
+}
+
+// 随机写入和删除，结果与 BTree 保持一致
+func TestSkipList_CompareWithBTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	sl, bt := NewConcurrentSkipList(), NewBTree()
+
+	randKey := func() []byte {
+		key := make([]byte, rnd.Intn(4))
+		rnd.Read(key)
+		return key
+	}
+	check := func() {
+		assert.Equal(t, bt.Size(), sl.Size())
+		for _, reverse := range []bool{false, true} {
+			iter1, iter2 := bt.Iterator(reverse), sl.Iterator(reverse)
+			for iter1.Valid() {
+				if !assert.True(t, iter2.Valid()) {
+					return
+				}
+				assert.True(t, bytes.Equal(iter1.Key(), iter2.Key()))
+				assert.Equal(t, iter1.Value(), iter2.Value())
+				iter1.Next()
+				iter2.Next()
+			}
+			assert.False(t, iter2.Valid())
+
+			seek := randKey()
+			iter1.Seek(seek)
+			iter2.Seek(seek)
+			assert.Equal(t, iter1.Valid(), iter2.Valid())
+			if iter1.Valid() {
+				assert.Equal(t, iter1.Key(), iter2.Key())
+			}
+		}
+	}
+
+	var written [][]byte
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 3000; i++ {
+			key := randKey()
+			written = append(written, key)
+			pos := &data.LogRecordPos{Fid: uint32(round), Offset: int64(i)}
+			bt.Put(key, pos)
+			sl.Put(key, pos)
+			assert.Equal(t, bt.Get(key), sl.Get(key))
+		}
+		for i := 0; i < 2500; i++ {
+			key := written[rnd.Intn(len(written))]
+			assert.Equal(t, bt.Delete(key), sl.Delete(key))
+			assert.Nil(t, sl.Get(key))
+		}
+		check()
+	}
+}
+
+// 一个 goroutine 写入，多个 goroutine 同时读取和遍历
+func TestSkipList_ConcurrentRead(t *testing.T) {
+	sl := NewConcurrentSkipList()
+	for i := 0; i < 1000; i += 2 {
+		sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	stop := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(reverse bool) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// 偶数 key 一直存在，遍历时有序并且不会遗漏
+				var prev []byte
+				var count int
+				for iter := sl.Iterator(reverse); iter.Valid(); iter.Next() {
+					if prev != nil {
+						assert.Equal(t, reverse, bytes.Compare(prev, iter.Key()) > 0)
+					}
+					prev = iter.Key()
+					if iter.Value().Fid == 1 {
+						count++
+					}
+				}
+				assert.Equal(t, 500, count)
+				assert.Equal(t, int64(998), sl.Get([]byte("key-0998")).Offset)
+			}
+		}(g%2 == 1)
+	}
+
+	for round := 0; round < 20; round++ {
+		for i := 1; i < 1000; i += 2 {
+			sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		}
+		for i := 1; i < 1000; i += 2 {
+			sl.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+		}
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, 500, sl.Size())
+}
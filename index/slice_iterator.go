@@ -0,0 +1,64 @@
+package index
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// sliceIterator 遍历已经按照顺序复制到数组中的数据
+type sliceIterator struct {
+	currIndex int     // 当前遍历的下标位置
+	reverse   bool    // 是否反向遍历
+	values    []*Item // key+位置索引信息，反向遍历时按照从大到小排列
+}
+
+func newSliceIterator(values []*Item, reverse bool) *sliceIterator {
+	return &sliceIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
+}
+
+// NewEmptyIterator 返回没有任何数据的迭代器
+func NewEmptyIterator(reverse bool) Iterator {
+	return newSliceIterator(nil, reverse)
+}
+
+func (si *sliceIterator) Rewind() {
+	si.currIndex = 0
+}
+
+func (si *sliceIterator) Seek(key []byte) {
+	if si.reverse {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return bytes.Compare(si.values[i].key, key) <= 0
+		})
+	} else {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return bytes.Compare(si.values[i].key, key) >= 0
+		})
+	}
+}
+
+func (si *sliceIterator) Next() {
+	si.currIndex++
+}
+
+func (si *sliceIterator) Valid() bool {
+	return si.currIndex < len(si.values)
+}
+
+func (si *sliceIterator) Key() []byte {
+	return si.values[si.currIndex].key
+}
+
+func (si *sliceIterator) Value() *data.LogRecordPos {
+	return si.values[si.currIndex].pos
+}
+
+func (si *sliceIterator) Close() {
+	si.values = nil
+}
@@ -0,0 +1,141 @@
+package bitcask_go
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// scannedRecord 从数据文件中读取的一条记录
+type scannedRecord struct {
+	logRecord *data.LogRecord // Key 为去掉事务序列号的 key，只有范围删除记录保留 value
+	seqNo     uint64
+	pos       *data.LogRecordPos
+}
+
+// scannedFile 一个数据文件的读取结果
+type scannedFile struct {
+	records []*scannedRecord
+	size    int64 // 有效数据的长度
+	err     error
+}
+
+// fileScanner 使用多个 goroutine 并发读取数据文件，调用方按文件顺序处理读取结果，第一个文件从 startOffset 开始读取
+// 最多缓存 workers 个已经读取但是还没有处理的文件，限制加载索引时的内存占用
+type fileScanner struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results []chan *scannedFile
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newFileScanner(ctx context.Context, files []*data.DataFile, startOffset int64, workers int) *fileScanner {
+	ctx, cancel := context.WithCancel(ctx)
+	fs := &fileScanner{
+		ctx:     ctx,
+		cancel:  cancel,
+		results: make([]chan *scannedFile, len(files)),
+		slots:   make(chan struct{}, workers),
+	}
+	for i := range fs.results {
+		fs.results[i] = make(chan *scannedFile, 1)
+	}
+
+	// 按文件顺序分发任务，先占用缓存位置，保证前面的文件总是可以被读取
+	jobs := make(chan int)
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+		defer close(jobs)
+		for i := range files {
+			select {
+			case fs.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		fs.wg.Add(1)
+		go func() {
+			defer fs.wg.Done()
+			for i := range jobs {
+				var offset int64
+				if i == 0 {
+					offset = startOffset
+				}
+				fs.results[i] <- scanDataFile(ctx, files[i], offset)
+			}
+		}()
+	}
+	return fs
+}
+
+// next 等待第 i 个文件的读取结果
+func (fs *fileScanner) next(i int) *scannedFile {
+	select {
+	case result := <-fs.results[i]:
+		return result
+	case <-fs.ctx.Done():
+		return &scannedFile{err: fs.ctx.Err()}
+	}
+}
+
+// release 处理完一个文件之后释放缓存位置
+func (fs *fileScanner) release() {
+	<-fs.slots
+}
+
+// stop 停止读取并等待所有 goroutine 退出
+func (fs *fileScanner) stop() {
+	fs.cancel()
+	fs.wg.Wait()
+}
+
+// scanDataFile 读取数据文件中从 offset 开始的所有记录
+func scanDataFile(ctx context.Context, dataFile *data.DataFile, offset int64) *scannedFile {
+	result := &scannedFile{}
+	for {
+		if err := ctx.Err(); err != nil {
+			result.err = err
+			return result
+		}
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err != io.EOF {
+				result.err = err
+			}
+			break
+		}
+
+		// 解析 key，拿到事务序列号，复制 key 之后读取的数据可以被回收
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		logRecord.Key = append([]byte(nil), realKey...)
+		if logRecord.Type != data.LogRecordRangeDeleted {
+			logRecord.Value = nil
+		}
+		result.records = append(result.records, &scannedRecord{
+			logRecord: logRecord,
+			seqNo:     seqNo,
+			pos: &data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+			},
+		})
+
+		// 递增 offset，下一次从新的位置开始读
+		offset += size
+	}
+	result.size = offset
+	return result
+}
@@ -0,0 +1,72 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_LoadIndex_Parallel(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-loader-1")
+	opt.DirPath = dir
+	opt.DataFileSize = 4 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 数据分布在多个文件中，后面的文件覆盖前面的写入，事务跨越多个文件
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("old"))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 300; i++ {
+		err := wb.Put(utils.GetTestKey(i), []byte("batch"))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	for i := 900; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.DeleteRange(utils.GetTestKey(800), utils.GetTestKey(850))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(0), []byte("new"))
+	assert.Nil(t, err)
+	assert.True(t, len(db.olderFiles) > 4)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 不同的并发数量加载的结果相同
+	for _, workers := range []int{1, 2, 8} {
+		opt.IndexLoadWorkers = workers
+		db2, err := Open(opt)
+		assert.Nil(t, err)
+		assert.Equal(t, 850, len(db2.ListKeys()))
+		val, err := db2.Get(utils.GetTestKey(0))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new"), val)
+		val, err = db2.Get(utils.GetTestKey(100))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch"), val)
+		val, err = db2.Get(utils.GetTestKey(500))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+		_, err = db2.Get(utils.GetTestKey(820))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db2.Get(utils.GetTestKey(950))
+		assert.Equal(t, ErrKeyNotFound, err)
+		err = db2.Close()
+		assert.Nil(t, err)
+	}
+
+	// 并发数量必须为正数
+	opt.IndexLoadWorkers = 0
+	_, err = Open(opt)
+	assert.NotNil(t, err)
+}
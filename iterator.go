@@ -14,7 +14,11 @@ type Iterator struct {
 }
 
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	return db.newIterator(db.index, opts)
+}
+
+func (db *DB) newIterator(idx index.Indexer, opts IteratorOptions) *Iterator {
+	indexIter := idx.Iterator(opts.Reverse)
 	return &Iterator{
 		db:        db,
 		indexIter: indexIter,
@@ -58,6 +62,14 @@ func (it *Iterator) Value() ([]byte, error) {
 	return it.db.getValueByPosition(logRecordPos)
 }
 
+// ValueWithMeta 当前位置的 value 数据和元信息
+func (it *Iterator) ValueWithMeta() ([]byte, *RecordMeta, error) {
+	logRecordPos := it.indexIter.Value()
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	return it.db.getValueWithMeta(logRecordPos)
+}
+
 // Close 关闭迭代器，释放资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
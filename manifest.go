@@ -0,0 +1,369 @@
+package bitcask_go
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+const (
+	manifestKey          = "manifest"
+	manifestTempFileName = data.ManifestFileName + ".tmp"
+	// manifest 追加的记录数量超过阈值之后重写为只有一条记录的新文件
+	manifestRewriteEdits = 1024
+)
+
+// manifestState 数据库当前有效的文件集合
+type manifestState struct {
+	nextFileId    uint32   // 下一个可以分配的文件 id，为 0 表示还没有数据文件
+	activeFileId  uint32   // 活跃文件 id
+	mergedFileIds []uint32 // merge 生成的数据文件 id，从 hint 文件中加载索引
+	hintFile      string   // merge 生成的 hint 文件名称，为空表示没有
+	dataFileIds   []uint32 // 需要扫描加载索引的旧数据文件 id，按写入顺序排列，不包括活跃文件
+	seqNo         uint64   // 写入这条记录时的事务序列号
+	version       uint64   // 写入这条记录时的最大版本号，merge 丢弃了最新版本的数据时重启也不会重复使用版本号
+}
+
+// manifest 只追加写入的文件集合记录，每次变更追加一条完整的 manifestState，加载时以最后一条完整的记录为准
+type manifest struct {
+	dirPath string
+	file    *data.DataFile
+	edits   int // 当前文件中的记录数量
+	state   manifestState
+}
+
+// loadManifest 加载数据目录中的 manifest，不存在时根据旧版本的目录结构生成
+func (db *DB) loadManifest() error {
+	dirPath := db.options.DirPath
+	m := &manifest{dirPath: dirPath}
+	db.manifest = m
+
+	if _, err := os.Stat(filepath.Join(dirPath, data.ManifestFileName)); err == nil {
+		return m.load()
+	}
+
+	// 旧版本的数据目录，先处理没有加载的 merge 目录，再扫描数据文件
+	if err := db.loadMergeFiles(); err != nil {
+		return err
+	}
+	state, err := db.scanLegacyFiles()
+	if err != nil {
+		return err
+	}
+	m.state = state
+	return nil
+}
+
+// scanLegacyFiles 扫描旧版本的数据目录，得到有效的文件集合
+func (db *DB) scanLegacyFiles() (manifestState, error) {
+	var state manifestState
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return state, err
+	}
+
+	var fileIds []int
+	// 遍历目录中所有文件，找到所有 .data 后缀的文件
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			splitNames := strings.Split(entry.Name(), ".")
+			fileId, err := strconv.Atoi(splitNames[0])
+			// 数据目录可能被损坏
+			if err != nil {
+				return state, ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	if len(fileIds) == 0 {
+		return state, nil
+	}
+	sort.Ints(fileIds)
+
+	// 执行过 merge 时，比 merge 边界更小的文件从 hint 文件中加载索引
+	var nonMergeFileId uint32
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.MergeFinishedFileName)); err == nil {
+		if nonMergeFileId, err = db.getNonMergeFileId(db.options.DirPath); err != nil {
+			return state, err
+		}
+		state.hintFile = data.HintFileName
+	}
+
+	for _, fid := range fileIds[:len(fileIds)-1] {
+		if uint32(fid) < nonMergeFileId {
+			state.mergedFileIds = append(state.mergedFileIds, uint32(fid))
+		} else {
+			state.dataFileIds = append(state.dataFileIds, uint32(fid))
+		}
+	}
+	state.activeFileId = uint32(fileIds[len(fileIds)-1])
+	state.nextFileId = state.activeFileId + 1
+	return state, nil
+}
+
+// removeObsoleteFiles 删除不在 manifest 中的数据文件和 hint 文件，包括 merge 失败或者崩溃时遗留的文件
+func (db *DB) removeObsoleteFiles() error {
+	state := &db.manifest.state
+	live := make(map[string]bool)
+	if state.nextFileId > 0 {
+		live[filepath.Base(data.GetDataFileName("", state.activeFileId))] = true
+	}
+	for _, fid := range state.mergedFileIds {
+		live[filepath.Base(data.GetDataFileName("", fid))] = true
+	}
+	for _, fid := range state.dataFileIds {
+		live[filepath.Base(data.GetDataFileName("", fid))] = true
+	}
+	if state.hintFile != "" {
+		live[state.hintFile] = true
+	}
+
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		obsolete := name == data.MergeFinishedFileName || name == manifestTempFileName
+		// 流式写入的临时文件只在写入期间使用，重启时都是残留的文件
+		if matched, _ := filepath.Match(streamTempFilePattern, name); matched {
+			obsolete = true
+		}
+		if strings.HasSuffix(name, data.DataFileNameSuffix) ||
+			strings.HasSuffix(name, data.HintFileNameSuffix) ||
+			name == data.HintFileName {
+			obsolete = !live[name]
+		}
+		if obsolete {
+			if err := os.Remove(filepath.Join(db.options.DirPath, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// allocFileId 分配一个新的文件 id，调用时必须持有 db.mu 写锁
+func (db *DB) allocFileId() uint32 {
+	fileId := db.manifest.state.nextFileId
+	db.manifest.state.nextFileId++
+	return fileId
+}
+
+// load 读取 manifest 文件，最后一条记录可能没有写完整，忽略
+func (m *manifest) load() error {
+	file, err := data.OpenManifestFile(m.dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var offset int64
+	found := false
+	for {
+		logRecord, size, err := file.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == data.ErrInvalidCRC {
+				break
+			}
+			return err
+		}
+		state, err := decodeManifestState(logRecord.Value)
+		if err != nil {
+			return err
+		}
+		m.state = state
+		found = true
+		offset += size
+	}
+	if !found {
+		return ErrDataDirectoryCorrupted
+	}
+	return nil
+}
+
+// commit 追加一条新的文件集合记录并持久化，写入成功之后才生效
+func (m *manifest) commit(state manifestState) error {
+	if m.edits >= manifestRewriteEdits {
+		return m.rewrite(state)
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(manifestKey),
+		Value: encodeManifestState(state),
+	})
+	if err := m.file.Write(encRecord); err != nil {
+		return err
+	}
+	if err := m.file.Sync(); err != nil {
+		return err
+	}
+	m.state = state
+	m.edits++
+	return nil
+}
+
+// rewrite 将文件集合写入临时文件，再原子地替换 manifest 文件
+func (m *manifest) rewrite(state manifestState) error {
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(manifestKey),
+		Value: encodeManifestState(state),
+	})
+	tmpPath := filepath.Join(m.dirPath, manifestTempFileName)
+	if err := writeFileSync(tmpPath, encRecord); err != nil {
+		return err
+	}
+
+	if m.file != nil {
+		if err := m.file.Close(); err != nil {
+			return err
+		}
+		m.file = nil
+	}
+	if err := os.Rename(tmpPath, filepath.Join(m.dirPath, data.ManifestFileName)); err != nil {
+		return err
+	}
+	if err := syncDir(m.dirPath); err != nil {
+		return err
+	}
+
+	file, err := data.OpenManifestFile(m.dirPath)
+	if err != nil {
+		return err
+	}
+	m.file = file
+	m.state = state
+	m.edits = 1
+	return nil
+}
+
+func (m *manifest) close() error {
+	if m == nil || m.file == nil {
+		return nil
+	}
+	file := m.file
+	m.file = nil
+	return file.Close()
+}
+
+// clone 复制文件集合，用于生成新的记录
+func (s manifestState) clone() manifestState {
+	s.mergedFileIds = append([]uint32(nil), s.mergedFileIds...)
+	s.dataFileIds = append([]uint32(nil), s.dataFileIds...)
+	return s
+}
+
+func encodeManifestState(state manifestState) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64*(7+len(state.mergedFileIds)+len(state.dataFileIds))+len(state.hintFile))
+	buf = binary.AppendUvarint(buf, uint64(state.nextFileId))
+	buf = binary.AppendUvarint(buf, uint64(state.activeFileId))
+	buf = binary.AppendUvarint(buf, state.seqNo)
+	buf = binary.AppendUvarint(buf, state.version)
+	buf = binary.AppendUvarint(buf, uint64(len(state.hintFile)))
+	buf = append(buf, state.hintFile...)
+	buf = binary.AppendUvarint(buf, uint64(len(state.mergedFileIds)))
+	for _, fid := range state.mergedFileIds {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(state.dataFileIds)))
+	for _, fid := range state.dataFileIds {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+	}
+	return buf
+}
+
+func decodeManifestState(buf []byte) (manifestState, error) {
+	var state manifestState
+	index := 0
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return 0, ErrDataDirectoryCorrupted
+		}
+		index += n
+		return v, nil
+	}
+	fileIds := func() ([]uint32, error) {
+		num, err := next()
+		if err != nil {
+			return nil, err
+		}
+		var ids []uint32
+		for i := uint64(0); i < num; i++ {
+			fid, err := next()
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, uint32(fid))
+		}
+		return ids, nil
+	}
+
+	nextFileId, err := next()
+	if err != nil {
+		return state, err
+	}
+	activeFileId, err := next()
+	if err != nil {
+		return state, err
+	}
+	if state.seqNo, err = next(); err != nil {
+		return state, err
+	}
+	if state.version, err = next(); err != nil {
+		return state, err
+	}
+	hintLen, err := next()
+	if err != nil {
+		return state, err
+	}
+	if uint64(len(buf)-index) < hintLen {
+		return state, ErrDataDirectoryCorrupted
+	}
+	state.hintFile = string(buf[index : index+int(hintLen)])
+	index += int(hintLen)
+	if state.mergedFileIds, err = fileIds(); err != nil {
+		return state, err
+	}
+	if state.dataFileIds, err = fileIds(); err != nil {
+		return state, err
+	}
+	state.nextFileId, state.activeFileId = uint32(nextFileId), uint32(activeFileId)
+	return state, nil
+}
+
+// writeFileSync 写入文件并持久化
+func writeFileSync(path string, buf []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir 持久化目录项，保证 rename 的结果不会丢失
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dir.Close()
+	}()
+	return dir.Sync()
+}
@@ -0,0 +1,131 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_Manifest(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-manifest-1")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.不在 manifest 中的文件在打开时删除，manifest 末尾不完整的记录被忽略
+	orphanFile := data.GetDataFileName(dir, 9999)
+	err = os.WriteFile(orphanFile, []byte("orphan"), os.ModePerm)
+	assert.Nil(t, err)
+	orphanHint := filepath.Join(dir, data.GetHintFileName(9998))
+	err = os.WriteFile(orphanHint, []byte("orphan"), os.ModePerm)
+	assert.Nil(t, err)
+	manifestFile, err := os.OpenFile(filepath.Join(dir, data.ManifestFileName), os.O_APPEND|os.O_WRONLY, os.ModePerm)
+	assert.Nil(t, err)
+	_, err = manifestFile.Write([]byte{1, 2, 3, 4, 5, 6})
+	assert.Nil(t, err)
+	_ = manifestFile.Close()
+
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	_, err = os.Stat(orphanFile)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(orphanHint)
+	assert.True(t, os.IsNotExist(err))
+
+	// 2.merge 之后重启，参与 merge 的文件被删除
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+	for i := 0; i < 500; i++ {
+		err := db2.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db3.ListKeys()))
+	mergedFiles, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+	assert.True(t, len(mergedFiles) < len(dataFiles))
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 3.manifest 中的文件不存在，数据目录被损坏
+	err = os.Remove(mergedFiles[0])
+	assert.Nil(t, err)
+	_, err = Open(opt)
+	assert.Equal(t, ErrDataDirectoryCorrupted, err)
+}
+
+func TestDB_Manifest_Upgrade(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-manifest-2")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 没有 manifest 的旧版本数据目录，扫描数据文件之后生成 manifest
+	err = os.Remove(filepath.Join(dir, data.ManifestFileName))
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	_, err = os.Stat(filepath.Join(dir, data.ManifestFileName))
+	assert.Nil(t, err)
+	err = db2.Put(utils.GetTestKey(1000), utils.RandomValue(64))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestManifestState_Encode(t *testing.T) {
+	state := manifestState{
+		nextFileId:    8,
+		activeFileId:  7,
+		seqNo:         100,
+		version:       200,
+		hintFile:      "hint-index",
+		mergedFileIds: []uint32{5, 6},
+		dataFileIds:   []uint32{3, 7},
+	}
+	buf := encodeManifestState(state)
+	decoded, err := decodeManifestState(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, state, decoded)
+
+	// 所有字段都是必需的，不完整的记录返回错误
+	_, err = decodeManifestState(buf[:len(buf)-1])
+	assert.Equal(t, ErrDataDirectoryCorrupted, err)
+}
@@ -1,11 +1,11 @@
 package bitcask_go
 
 import (
+	"context"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strconv"
 
 	"github.com/xavier-tse/bitcask-go/data"
@@ -18,23 +18,32 @@ const (
 
 // Merge 清理无效数据，生成 Hint 文件
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background())
+}
+
+// MergeContext 同 Merge，ctx 结束时在处理下一条记录之前停止，并删除已经生成的文件
+// merge 完成时直接替换参与 merge 的文件，之前创建的迭代器读取被替换的文件中的数据时返回 ErrDataFileNotFound
+func (db *DB) MergeContext(ctx context.Context) (err error) {
+	db.mu.Lock()
 	// 数据库为空，直接返回
 	if db.activeFile == nil {
+		db.mu.Unlock()
 		return nil
 	}
-
-	db.mu.Lock()
 	if db.isMerging {
 		db.mu.Unlock()
 		return ErrMergeIsProgress
 	}
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
 
 	// 持久化当前活跃文件
 	if err := db.activeFile.Sync(); err != nil {
+		db.mu.Unlock()
 		return err
 	}
 	// 将当前活跃文件转换成旧的数据文件
@@ -44,49 +53,48 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return err
 	}
-	// 记录最近没有参与 merge 的文件 id
-	nonMergeFildId := db.activeFile.FileId
-
-	// 取出所有需要 merge 的文件
+	// 取出所有需要 merge 的文件，按照写入顺序处理，保证 hint 文件中同一个 key 的历史版本从旧到新排列
+	// merge 生成的文件 id 大于之后写入的文件，不能按照文件 id 排序
 	var mergeFiles []*data.DataFile
-	for _, file := range db.olderFiles {
-		mergeFiles = append(mergeFiles, file)
-	}
-	db.mu.Unlock()
-
-	sort.Slice(mergeFiles, func(i, j int) bool {
-		return mergeFiles[i].FileId < mergeFiles[j].FileId
-	})
-
-	mergePath := db.getMergePath()
-	// 如果目录存在，说明以前进行过 merge，删除
-	if _, err := os.Stat(mergePath); err != nil {
-		if err := os.RemoveAll(mergePath); err != nil {
-			return err
+	for _, fileIds := range [][]uint32{db.manifest.state.mergedFileIds, db.manifest.state.dataFileIds} {
+		for _, fid := range fileIds {
+			mergeFiles = append(mergeFiles, db.olderFiles[fid])
 		}
 	}
-	// 新建一个 merge 目录
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
-		return err
-	}
-	// 开启一个新的 bitcask 实例
-	mergeOptions := db.options
-	mergeOptions.DirPath = mergePath
-	mergeOptions.SyncWrites = false
-	mergeDB, err := Open(mergeOptions)
-	if err != nil {
-		return err
-	}
+	db.mu.Unlock()
 
+	// merge 生成的文件使用新分配的文件 id 直接写入数据目录，写入 manifest 之前不会生效
+	output := &mergeWriter{db: db}
+	db.mu.Lock()
+	hintFileName := data.GetHintFileName(db.allocFileId())
+	db.mu.Unlock()
 	// 打开 hint 文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, hintFileName)
 	if err != nil {
 		return err
 	}
+	// merge 失败或者被取消，删除已经生成的文件
+	defer func() {
+		_ = hintFile.Close()
+		if err != nil {
+			output.close()
+			_ = os.Remove(filepath.Join(db.options.DirPath, hintFileName))
+			output.remove()
+		}
+	}()
+	// 被压缩过滤器丢弃的数据
+	var drops []*compactionDrop
+	// 被重写的数据原来的位置和新的位置
+	var moves []*mergeMove
+	// 没有保留历史版本时不需要逐条检查
+	historyEnabled := db.historyEnabled()
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
 		var offset int64 = 0
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -94,22 +102,62 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
+			// 读取的数据计入后台任务的限速
+			if err := db.ioLimiter.WaitN(ctx, size); err != nil {
+				return err
+			}
+			// 范围删除和 keyspace 删除标记只作用于更早的数据，被删除的数据不会重写，标记也不需要保留
+			if logRecord.Type == data.LogRecordRangeDeleted || logRecord.Type == data.LogRecordBucketDropped {
+				// 历史版本保留了范围删除之前的数据时，逐个 key 写入删除记录，避免重启之后被删除的数据重新出现
+				if historyEnabled && logRecord.Type == data.LogRecordRangeDeleted {
+					markerPos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset}
+					if moves, err = db.mergeRangeDeleted(ctx, logRecord, markerPos, output, hintFile, moves); err != nil {
+						return err
+					}
+				}
+				offset += size
+				continue
+			}
 			// 解析实际的 key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
-			// 和内存索引中的索引位置对比，有效就重写
-			if logRecordPos != nil &&
-				logRecordPos.Fid == dataFile.FileId &&
-				logRecordPos.Offset == offset {
+			// 和内存索引中的索引位置对比，有效就交给压缩过滤器处理
+			logRecordPos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset}
+			db.mu.RLock()
+			rewrite := db.isLivePosition(logRecord.Bucket, realKey, logRecordPos)
+			db.mu.RUnlock()
+			if rewrite {
+				if rewrite, err = db.applyCompactionFilter(logRecord, realKey, logRecordPos, &drops); err != nil {
+					return err
+				}
+			}
+			// 需要保留的历史版本也要重写
+			retained := false
+			if historyEnabled {
+				db.mu.RLock()
+				retained = db.isHistoryRetained(logRecord.Bucket, realKey, dataFile.FileId, offset)
+				db.mu.RUnlock()
+			}
+			if rewrite || retained {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecord(logRecord)
+				pos, err := output.append(logRecord)
 				if err != nil {
 					return err
 				}
+				moves = append(moves, &mergeMove{bucket: logRecord.Bucket, key: realKey, oldPos: logRecordPos, newPos: pos})
 
 				// 将当前位置索引写入 hint 文件
-				if err := hintFile.WriteHintLogRecord(realKey, pos); err != nil {
+				if err := hintFile.WriteHintLogRecord(&data.LogRecord{
+					Key:       realKey,
+					Type:      logRecord.Type,
+					Bucket:    logRecord.Bucket,
+					Version:   logRecord.Version,
+					Timestamp: logRecord.Timestamp,
+				}, pos); err != nil {
+					return err
+				}
+				// 写入的数据计入后台任务的限速
+				if err := db.ioLimiter.WaitN(ctx, int64(pos.Size)); err != nil {
 					return err
 				}
 			}
@@ -122,37 +170,206 @@ func (db *DB) Merge() error {
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
-	if err := mergeDB.Sync(); err != nil {
+	if err := output.sync(); err != nil {
 		return err
 	}
 
-	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
-	if err != nil {
-		return err
+	// 写入新的文件集合，merge 生成的文件替换所有参与 merge 的文件
+	merged := make(map[uint32]bool, len(mergeFiles))
+	for _, file := range mergeFiles {
+		merged[file.FileId] = true
 	}
-	mergeFinishedRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFildId))),
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	oldHintFile := db.manifest.state.hintFile
+	state := db.manifest.state.clone()
+	state.mergedFileIds = output.fileIds()
+	state.hintFile = hintFileName
+	state.dataFileIds = nil
+	for _, fid := range db.manifest.state.dataFileIds {
+		if !merged[fid] {
+			state.dataFileIds = append(state.dataFileIds, fid)
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinishedRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
-		return err
+	// 被压缩过滤器丢弃的 key 作为一次删除通知订阅者，版本号在写入 manifest 之前分配，重启之后不会被复用
+	var dropVersion uint64
+	if len(drops) > 0 {
+		dropVersion, _ = db.nextVersion()
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
+	state.seqNo, state.version = db.seqNo, db.version
+	if err = db.manifest.commit(state); err != nil {
 		return err
 	}
 
+	// manifest 写入之后 merge 已经生效，之后的步骤失败不影响数据
+	db.applyMergeResult(merged, output.files, moves, drops, dropVersion)
+	if oldHintFile != "" && oldHintFile != hintFileName {
+		_ = os.Remove(filepath.Join(db.options.DirPath, oldHintFile))
+	}
+	for fid := range merged {
+		_ = os.Remove(data.GetDataFileName(db.options.DirPath, fid))
+	}
+	return nil
+}
+
+// mergeMove merge 重写的一条数据
+type mergeMove struct {
+	bucket []byte
+	key    []byte
+	oldPos *data.LogRecordPos
+	newPos *data.LogRecordPos
+}
+
+// applyMergeResult 使用 merge 生成的文件替换参与 merge 的文件，调用时必须持有 db.mu 写锁
+// 索引和历史版本中仍然指向原来位置的数据更新到新的位置，merge 期间被覆盖的数据计入可回收的空间
+func (db *DB) applyMergeResult(merged map[uint32]bool, files []*data.DataFile, moves []*mergeMove, drops []*compactionDrop, dropVersion uint64) {
+	// 被压缩过滤器丢弃的 key 从索引中删除并通知订阅者，merge 期间被重新写入的 key 不受影响
+	var changes []*data.LogRecord
+	for _, drop := range drops {
+		if !db.isLivePosition(drop.bucket, drop.key, drop.pos) {
+			continue
+		}
+		db.indexDelete(drop.bucket, drop.key)
+		db.txnTracker.markWritten(drop.bucket, drop.key)
+		changes = append(changes, &data.LogRecord{Key: drop.key, Type: data.LogRecordDeleted, Bucket: drop.bucket, Version: dropVersion})
+	}
+	db.watchHub.notify(changes)
+
+	for _, move := range moves {
+		live := db.isLivePosition(move.bucket, move.key, move.oldPos)
+		if live {
+			db.bucketIndex(move.bucket, false).Put(move.key, move.newPos)
+		}
+		retained := db.moveHistoryPos(move.bucket, move.key, move.oldPos, move.newPos)
+		if !live && !retained {
+			db.markReclaimable(move.bucket, move.newPos)
+		}
+	}
+
+	for fid := range merged {
+		if file := db.olderFiles[fid]; file != nil {
+			_ = file.Close()
+		}
+		delete(db.olderFiles, fid)
+		delete(db.reclaimable, fid)
+		for _, bucketFiles := range db.bucketReclaimable {
+			delete(bucketFiles, fid)
+		}
+	}
+	for _, file := range files {
+		db.olderFiles[file.FileId] = file
+	}
+	db.loadedHintFile = db.manifest.state.hintFile
+}
+
+// mergeRangeDeleted 为历史版本中位于范围删除标记上的删除版本逐个 key 写入删除记录和 hint 记录
+func (db *DB) mergeRangeDeleted(ctx context.Context, marker *data.LogRecord, markerPos *data.LogRecordPos,
+	output *mergeWriter, hintFile *data.DataFile, moves []*mergeMove) ([]*mergeMove, error) {
+	db.mu.RLock()
+	keys := db.rangeDeletedHistory(markerPos.Fid, markerPos.Offset)
+	db.mu.RUnlock()
+
+	for _, key := range keys {
+		pos, err := output.append(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Type:      data.LogRecordDeleted,
+			Version:   marker.Version,
+			Timestamp: marker.Timestamp,
+		})
+		if err != nil {
+			return moves, err
+		}
+		moves = append(moves, &mergeMove{key: key, oldPos: markerPos, newPos: pos})
+
+		if err := hintFile.WriteHintLogRecord(&data.LogRecord{
+			Key:       key,
+			Type:      data.LogRecordDeleted,
+			Version:   marker.Version,
+			Timestamp: marker.Timestamp,
+		}, pos); err != nil {
+			return moves, err
+		}
+		// 写入的数据计入后台任务的限速
+		if err := db.ioLimiter.WaitN(ctx, int64(pos.Size)); err != nil {
+			return moves, err
+		}
+	}
+	return moves, nil
+}
+
+// mergeWriter 将 merge 重写的数据追加写入新分配 id 的数据文件
+type mergeWriter struct {
+	db    *DB
+	files []*data.DataFile
+}
+
+// append 追加写入一条数据，文件大小超过阈值后切换到新的文件
+func (mw *mergeWriter) append(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	encRecord, size := data.EncodeLogRecord(logRecord)
+	var activeFile *data.DataFile
+	if len(mw.files) > 0 {
+		activeFile = mw.files[len(mw.files)-1]
+	}
+	if activeFile == nil || activeFile.WriteOff+size > mw.db.options.DataFileSize {
+		mw.db.mu.Lock()
+		fileId := mw.db.allocFileId()
+		mw.db.mu.Unlock()
+		dataFile, err := data.OpenDataFile(mw.db.options.DirPath, fileId)
+		if err != nil {
+			return nil, err
+		}
+		mw.files = append(mw.files, dataFile)
+		activeFile = dataFile
+	}
+
+	writeOff := activeFile.WriteOff
+	if err := activeFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	return &data.LogRecordPos{
+		Fid:    activeFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	}, nil
+}
+
+func (mw *mergeWriter) sync() error {
+	for _, file := range mw.files {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (mw *mergeWriter) fileIds() []uint32 {
+	fileIds := make([]uint32, 0, len(mw.files))
+	for _, file := range mw.files {
+		fileIds = append(fileIds, file.FileId)
+	}
+	return fileIds
+}
+
+func (mw *mergeWriter) close() {
+	for _, file := range mw.files {
+		_ = file.Close()
+	}
+}
+
+// remove 删除已经生成的文件，用于 merge 失败时清理
+func (mw *mergeWriter) remove() {
+	for _, file := range mw.files {
+		_ = os.Remove(data.GetDataFileName(mw.db.options.DirPath, file.FileId))
+	}
+}
+
 func (db *DB) getMergePath() string {
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	base := path.Base(db.options.DirPath)
 	return filepath.Join(dir, base+mergeDirName)
 }
 
-// loadMergeFiles 加载 merge 数据目录
+// loadMergeFiles 加载旧版本 merge 生成的数据目录，只用于升级没有 manifest 的数据目录
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge 目录不存在直接返回
@@ -184,14 +401,14 @@ func (db *DB) loadMergeFiles() error {
 
 	nonMergeFildId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	// 删除旧的数据文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFildId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(path.Join(mergePath, fileName)); err == nil {
+		if _, err := os.Stat(fileName); err == nil {
 			if err := os.Remove(fileName); err != nil {
 				return err
 			}
@@ -218,7 +435,7 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
-	nonMergeFileId, err := strconv.Atoi(string(record.Key))
+	nonMergeFileId, err := strconv.Atoi(string(record.Value))
 	if err != nil {
 		return 0, err
 	}
@@ -226,22 +443,32 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 }
 
 // loadIndexFromHintFile 从 hint 文件中加载索引
-func (db *DB) loadIndexFromHintFile() error {
-	// 查看 hint 索引文件是否存在
-	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+func (db *DB) loadIndexFromHintFile(ctx context.Context) error {
+	// 没有执行过 merge
+	hintFileName := db.manifest.state.hintFile
+	if hintFileName == "" {
 		return nil
 	}
 
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, hintFileName)); err != nil {
+		return ErrDataDirectoryCorrupted
+	}
+
 	// 打开 hint 索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, hintFileName)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	// 读取文件中的索引
-	var offset int64 = 0
+	var offset, records int64 = 0, 0
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
 			if err == io.EOF {
@@ -250,10 +477,32 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
-		// 解码拿到实际的位置索引
+		// 解码拿到实际的位置索引，保留的历史版本中可能有删除的版本
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		deleted := logRecord.Type == data.LogRecordDeleted
+		db.addHistory(logRecord.Bucket, logRecord.Key, logRecord.Version, logRecord.Timestamp, pos, deleted)
+		if deleted {
+			db.indexDelete(logRecord.Bucket, logRecord.Key)
+		} else {
+			db.indexPut(logRecord.Bucket, logRecord.Key, pos)
+		}
+		if logRecord.Version > db.version {
+			db.version = logRecord.Version
+		}
 		offset += size
+		records++
 	}
+
+	db.recovery.HintFilesUsed++
+	db.recovery.BytesRead += offset
+	db.recovery.RecordsRead += records
+	db.reportRecoveryProgress(RecoveryProgress{
+		Phase:       RecoveryPhaseHint,
+		FilesDone:   1,
+		FilesTotal:  1,
+		BytesRead:   offset,
+		BytesTotal:  hintFile.WriteOff,
+		RecordsRead: records,
+	})
 	return nil
 }
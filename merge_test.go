@@ -0,0 +1,328 @@
+package bitcask_go
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_Merge(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-1")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启之后加载 merge 的数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+}
+
+func TestDB_Merge_Apply(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-5")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	dataFileSize := func() int64 {
+		var size int64
+		names, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+		for _, name := range names {
+			info, err := os.Stat(name)
+			assert.Nil(t, err)
+			size += info.Size()
+		}
+		return size
+	}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 1000; j++ {
+			err := db.Put(utils.GetTestKey(j), utils.RandomValue(64))
+			assert.Nil(t, err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	before := dataFileSize()
+
+	// 1.merge 完成之后立即生效，删除被替换的文件
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	size := dataFileSize()
+	assert.True(t, size < before/2)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+
+	// 2.再次 merge 不会重复生成数据
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, size, dataFileSize())
+
+	// 3.merge 期间的写入不受影响，被覆盖的重写数据计入可回收的空间
+	done := make(chan struct{})
+	values := make(map[int][]byte)
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			key := 500 + i%500
+			value := utils.RandomValue(64)
+			assert.Nil(t, db.Put(utils.GetTestKey(key), value))
+			values[key] = value
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		err = db.Merge()
+		assert.Nil(t, err)
+	}
+	<-done
+	for key, value := range values {
+		val, err := db.Get(utils.GetTestKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	reclaimable := db.Stat().ReclaimableSize
+
+	// 4.重启之后数据和可回收的空间不变
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	assert.Equal(t, reclaimable, db.Stat().ReclaimableSize)
+	for key, value := range values {
+		val, err := db.Get(utils.GetTestKey(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-2")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	// 取消 merge 之后删除已经生成的文件
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	hintFiles, _ := filepath.Glob(filepath.Join(dir, "*"+data.HintFileNameSuffix))
+	assert.Equal(t, 0, len(hintFiles))
+	assert.Equal(t, "", db.manifest.state.hintFile)
+
+	// 可以再次 merge
+	err = db.Merge()
+	assert.Nil(t, err)
+	hintFiles, _ = filepath.Glob(filepath.Join(dir, "*"+data.HintFileNameSuffix))
+	assert.Equal(t, 1, len(hintFiles))
+	assert.Equal(t, filepath.Base(hintFiles[0]), db.manifest.state.hintFile)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+func TestDB_Merge_CompactionFilter(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-3")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	dropKeys := make(map[string]bool)
+	rewriteKeys := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		if i < 30 {
+			dropKeys[string(utils.GetTestKey(i))] = true
+		} else if i < 60 {
+			rewriteKeys[string(utils.GetTestKey(i))] = true
+		}
+	}
+	opt.CompactionFilter = func(entry *CompactionEntry) (CompactionDecision, []byte) {
+		if dropKeys[string(entry.Key)] {
+			return CompactionDrop, nil
+		}
+		if rewriteKeys[string(entry.Key)] {
+			return CompactionRewrite, append([]byte("new-"), entry.Value...)
+		}
+		return CompactionKeep, nil
+	}
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("value"))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 1.merge 完成之后丢弃的 key 立即从索引中删除，重写的数据立即可见
+	check := func(db *DB) {
+		assert.Equal(t, 70, len(db.ListKeys()))
+		_, err := db.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get(utils.GetTestKey(40))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new-value"), val)
+		val, err = db.Get(utils.GetTestKey(80))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+	}
+	check(db)
+
+	// 2.重启之后结果不变
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	check(db2)
+}
+
+func TestDB_Merge_RateLimit(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-4")
+	opt.DirPath = dir
+	opt.BackgroundBytesPerSec = 16 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 1.读写的数据超过限额，merge 需要等待
+	start := time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 500*time.Millisecond)
+
+	// 2.限速时 merge 可以被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = db.MergeContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestDB_Merge_CompactionFilter_Watch(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-6")
+	opt.DirPath = dir
+	opt.CompactionFilter = func(entry *CompactionEntry) (CompactionDecision, []byte) {
+		if string(entry.Key) == "user/1" {
+			return CompactionDrop, nil
+		}
+		return CompactionKeep, nil
+	}
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("user/1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("user/2"), []byte("b")))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := db.Watch(ctx, []byte("user/"))
+
+	// 1.丢弃的 key 在 merge 完成之后收到删除事件，版本号大于之前的写入
+	assert.Nil(t, db.Merge())
+	resp := <-ch
+	assert.Equal(t, 1, len(resp.Events))
+	assert.Equal(t, WatchEventDelete, resp.Events[0].Type)
+	assert.Equal(t, []byte("user/1"), resp.Events[0].Key)
+	assert.Equal(t, db.version, resp.Events[0].SeqNo)
+
+	// 2.之后的写入版本号继续递增
+	assert.Nil(t, db.Put([]byte("user/3"), []byte("c")))
+	next := <-ch
+	assert.True(t, next.Events[0].SeqNo > resp.Events[0].SeqNo)
+}
+
+func TestDB_Merge_CompactionFilter_RewriteVersion(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-7")
+	opt.DirPath = dir
+	opt.HistoryVersions = 3
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt.CompactionFilter = func(entry *CompactionEntry) (CompactionDecision, []byte) {
+		// 重写之后取消 merge，原来的数据仍然保留在数据文件中
+		cancel()
+		return CompactionRewrite, []byte("b")
+	}
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("a")))
+	assert.Equal(t, context.Canceled, db.MergeContext(ctx))
+
+	// 1.重启之后同一个版本号只保留最后写入的数据
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	history, err := db.History(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, []byte("b"), history[0].Value)
+	val, err := db.GetAt(utils.GetTestKey(1), history[0].Version)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
@@ -0,0 +1,55 @@
+package bitcask_go
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// MultiGet 批量读取数据，返回的 values 和 errs 与 keys 一一对应
+// 在一次读锁中获取所有位置索引，按文件分组并按偏移量排序后读取，不同文件之间并行读取
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 根据内存索引找到所有 key 的位置，按文件 id 分组
+	type readTask struct {
+		idx int
+		pos *data.LogRecordPos
+	}
+	tasks := make(map[uint32][]readTask)
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		logRecordPos := db.index.Get(key)
+		if logRecordPos == nil {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		tasks[logRecordPos.Fid] = append(tasks[logRecordPos.Fid], readTask{idx: i, pos: logRecordPos})
+	}
+
+	// 每个文件内按偏移量顺序读取
+	var wg sync.WaitGroup
+	for _, fileTasks := range tasks {
+		wg.Add(1)
+		go func(fileTasks []readTask) {
+			defer wg.Done()
+			sort.Slice(fileTasks, func(i, j int) bool {
+				return fileTasks[i].pos.Offset < fileTasks[j].pos.Offset
+			})
+			for _, task := range fileTasks {
+				values[task.idx], errs[task.idx] = db.getValueByPosition(task.pos)
+			}
+		}(fileTasks)
+	}
+	wg.Wait()
+
+	return values, errs
+}
@@ -0,0 +1,59 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 写入的数据分布在多个文件中
+	expected := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		val := utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), val)
+		assert.Nil(t, err)
+		expected[i] = val
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	err = db.Delete(utils.GetTestKey(500))
+	assert.Nil(t, err)
+
+	keys := [][]byte{
+		utils.GetTestKey(999),
+		utils.GetTestKey(0),
+		utils.GetTestKey(500),
+		nil,
+		utils.GetTestKey(1000),
+		utils.GetTestKey(321),
+	}
+	values, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(values))
+	assert.Equal(t, len(keys), len(errs))
+
+	assert.Nil(t, errs[0])
+	assert.Equal(t, expected[999], values[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, expected[0], values[1])
+	assert.Equal(t, ErrKeyNotFound, errs[2])
+	assert.Equal(t, ErrKeyIsEmpty, errs[3])
+	assert.Equal(t, ErrKeyNotFound, errs[4])
+	assert.Nil(t, errs[5])
+	assert.Equal(t, expected[321], values[5])
+
+	// 没有 key
+	values, errs = db.MultiGet(nil)
+	assert.Equal(t, 0, len(values))
+	assert.Equal(t, 0, len(errs))
+}
@@ -1,6 +1,10 @@
 package bitcask_go
 
-import "os"
+import (
+	"os"
+	"runtime"
+	"time"
+)
 
 type Options struct {
 	// 数据目录
@@ -14,6 +18,65 @@ type Options struct {
 
 	// 索引类型
 	IndexType IndexType
+
+	// 每个 Watch 订阅者缓冲的最大事件数量
+	WatchBufferSize int
+
+	// 启动时并发读取数据文件加载索引的 goroutine 数量，也是同时缓存在内存中的最大文件数量
+	IndexLoadWorkers int
+
+	// 内存索引的分片数量，大于 1 时按照 key 的哈希值分散到多个索引中，减少并发写入时的锁竞争
+	// 磁盘 B+ 树索引不分片，IndexType 为 BPTree 时只作用于命名 keyspace 的内存索引
+	IndexShards int
+
+	// B+ 树索引的页面缓存大小，只在 IndexType 为 BPTree 时使用
+	BPTreeCacheSize int64
+
+	// 所有 keyspace 的索引、历史版本和二级索引占用内存的上限，批量写入时累计整个批次增加的内存
+	// 达到之后写入新的 key 返回 ErrIndexMemoryExceeded，覆盖和删除已有的 key 不受影响
+	// 磁盘 B+ 树索引只计算页面缓存占用的内存，为 0 表示不限制
+	MaxIndexMemory int64
+
+	// 后台持久化 B+ 树索引的时间间隔，重启时只需要重放上一次持久化之后写入的数据，为 0 表示只在关闭时持久化
+	IndexCheckpointInterval time.Duration
+
+	// 启动时的加载进度回调，每处理完一个文件调用一次，在 Open 的 goroutine 中同步调用
+	OnRecoveryProgress func(progress RecoveryProgress)
+
+	// 默认 keyspace 中每个 key 保留的最大版本数量（包括最新版本），为 0 表示不限制数量
+	// 和 HistoryRetention 都为 0 时不保留历史版本
+	HistoryVersions int
+
+	// 默认 keyspace 中历史版本的保留时间，为 0 表示不限制时间
+	HistoryRetention time.Duration
+
+	// 默认 keyspace 的二级索引，key 为索引名称，写入和删除时同步更新，打开数据库时从数据重建
+	// 提取索引 key 需要完整的 value，设置之后 PutReader 写入的 value 也会被完整读取到内存中
+	SecondaryIndexes map[string]IndexExtractor
+
+	// 压缩过滤器，merge 时对每条有效数据调用，可以保留、丢弃或者重写数据
+	CompactionFilter CompactionFilter
+
+	// 触发后台自动 merge 的可回收空间占比，为 0 表示不自动 merge
+	AutoMergeRatio float32
+
+	// 触发后台自动 merge 的最小可回收字节数
+	AutoMergeMinBytes int64
+
+	// 后台检查是否需要 merge 的时间间隔
+	AutoMergeInterval time.Duration
+
+	// 允许后台自动 merge 的时间段，为空表示不限制
+	AutoMergeWindow TimeWindow
+
+	// merge 等后台任务每秒读写数据文件的最大字节数，所有后台任务共享，为 0 表示不限速
+	BackgroundBytesPerSec int64
+}
+
+// TimeWindow 一天中的时间段，Start 和 End 为距离当地零点的时长，End 小于 Start 时表示跨过零点
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
 }
 
 // IteratorOptions 迭代器配置项
@@ -34,17 +97,49 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// TxnOptions 事务配置项
+type TxnOptions struct {
+	// 一个事务中最大的写入数据量
+	MaxWriteNum uint
+
+	// 提交时是否持久化
+	SyncWrites bool
+}
+
 type IndexType = int8
 
 const (
 	BTree IndexType = iota + 1
+
+	// ART 自适应基数树索引
+	ART
+
+	// BPTree 磁盘 B+ 树索引，只用于默认 keyspace，命名 keyspace 仍然使用内存中的 BTree 索引
+	// 默认 keyspace 中 key 的长度不能超过 index.BPTreeMaxKeyLen，否则写入返回 ErrKeyTooLarge
+	BPTree
+
+	// SkipList 跳表索引，读取不需要加锁，适合大量并发读取的场景
+	SkipList
+
+	// Hash 哈希索引，内存占用更少，适合只有点查询的场景，迭代器、ListKeys 和 Fold 每次需要复制并排序所有 key
+	Hash
 )
 
 var DefaultOptions = Options{
-	DirPath:      os.TempDir(),
-	DataFileSize: 256 * 1024 * 1024, // 256MB
-	SyncWrites:   false,
-	IndexType:    BTree,
+	DirPath:          os.TempDir(),
+	DataFileSize:     256 * 1024 * 1024, // 256MB
+	SyncWrites:       false,
+	IndexType:        BTree,
+	WatchBufferSize:  1024,
+	IndexLoadWorkers: runtime.NumCPU(),
+	IndexShards:      1,
+
+	BPTreeCacheSize:         64 * 1024 * 1024, // 64MB
+	IndexCheckpointInterval: time.Minute,
+
+	AutoMergeRatio:    0,
+	AutoMergeMinBytes: 32 * 1024 * 1024, // 32MB
+	AutoMergeInterval: time.Minute,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -56,3 +151,8 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
 }
+
+var DefaultTxnOptions = TxnOptions{
+	MaxWriteNum: 10000,
+	SyncWrites:  true,
+}
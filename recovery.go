@@ -0,0 +1,73 @@
+package bitcask_go
+
+import (
+	"time"
+)
+
+// RecoveryPhase 启动时加载数据的阶段
+type RecoveryPhase int8
+
+const (
+	// RecoveryPhaseManifest 加载有效的文件集合，旧版本的数据目录会先处理 merge 目录
+	RecoveryPhaseManifest RecoveryPhase = iota + 1
+
+	// RecoveryPhaseHint 从 hint 文件中加载 merge 生成的数据的索引
+	RecoveryPhaseHint
+
+	// RecoveryPhaseDataScan 扫描数据文件加载索引
+	RecoveryPhaseDataScan
+)
+
+func (p RecoveryPhase) String() string {
+	switch p {
+	case RecoveryPhaseManifest:
+		return "manifest"
+	case RecoveryPhaseHint:
+		return "hint"
+	case RecoveryPhaseDataScan:
+		return "data-scan"
+	default:
+		return "unknown"
+	}
+}
+
+// RecoveryProgress 启动时的加载进度，每处理完一个文件回调一次
+type RecoveryProgress struct {
+	Phase       RecoveryPhase
+	FilesDone   int           // 当前阶段已经处理的文件数量
+	FilesTotal  int           // 当前阶段需要处理的文件数量
+	BytesRead   int64         // 当前阶段已经读取的字节数
+	BytesTotal  int64         // 当前阶段需要读取的字节数
+	RecordsRead int64         // 当前阶段已经读取的记录数量
+	Elapsed     time.Duration // 从开始打开数据库经过的时间
+}
+
+// RecoveryReport 打开数据库时的加载统计
+type RecoveryReport struct {
+	FilesScanned        int   // 扫描的数据文件数量
+	BytesRead           int64 // 从数据文件和 hint 文件中读取的字节数
+	RecordsRead         int64 // 从数据文件和 hint 文件中读取的记录数量
+	HintFilesUsed       int   // 使用的 hint 文件数量
+	DiscardedTxns       int   // 没有完成而被丢弃的事务数量
+	DiscardedTxnRecords int   // 没有完成的事务中被丢弃的记录数量
+	IndexCheckpointUsed bool  // 是否从磁盘索引的 checkpoint 恢复，只重放之后写入的数据
+
+	ManifestTime time.Duration // 加载文件集合的耗时
+	HintTime     time.Duration // 从 hint 文件加载索引的耗时
+	DataScanTime time.Duration // 扫描数据文件加载索引的耗时
+	TotalTime    time.Duration // 打开数据库的总耗时
+}
+
+// RecoveryReport 获取打开数据库时的加载统计
+func (db *DB) RecoveryReport() RecoveryReport {
+	return db.recovery
+}
+
+// reportRecoveryProgress 调用配置的加载进度回调
+func (db *DB) reportRecoveryProgress(progress RecoveryProgress) {
+	if db.options.OnRecoveryProgress == nil {
+		return
+	}
+	progress.Elapsed = time.Since(db.openTime)
+	db.options.OnRecoveryProgress(progress)
+}
@@ -0,0 +1,73 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_RecoveryReport(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-1")
+	opt.DirPath = dir
+	opt.DataFileSize = 32 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	// 写入一个没有完成的事务
+	for i := 0; i < 3; i++ {
+		_, err := db.appendLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(utils.GetTestKey(i), 100),
+			Value: utils.RandomValue(10),
+			Type:  data.LogRecordNormal,
+		})
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	var progresses []RecoveryProgress
+	opt.OnRecoveryProgress = func(progress RecoveryProgress) {
+		progresses = append(progresses, progress)
+	}
+	db2, err := Open(opt)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+
+	// 1.加载统计
+	report := db2.RecoveryReport()
+	assert.Equal(t, 1, report.HintFilesUsed)
+	assert.True(t, report.FilesScanned > 0)
+	assert.True(t, report.RecordsRead >= 1000+100+3)
+	assert.True(t, report.BytesRead > 0)
+	assert.Equal(t, 1, report.DiscardedTxns)
+	assert.Equal(t, 3, report.DiscardedTxnRecords)
+	assert.True(t, report.TotalTime >= report.DataScanTime)
+
+	// 2.每个阶段都有回调，扫描数据文件时每个文件回调一次
+	assert.Equal(t, RecoveryPhaseManifest, progresses[0].Phase)
+	assert.Equal(t, RecoveryPhaseHint, progresses[1].Phase)
+	assert.Equal(t, progresses[1].BytesTotal, progresses[1].BytesRead)
+	last := progresses[len(progresses)-1]
+	assert.Equal(t, RecoveryPhaseDataScan, last.Phase)
+	assert.Equal(t, report.FilesScanned, last.FilesTotal)
+	assert.Equal(t, last.FilesTotal, last.FilesDone)
+	assert.Equal(t, last.BytesTotal, last.BytesRead)
+	assert.Equal(t, 2+report.FilesScanned, len(progresses))
+}
@@ -0,0 +1,279 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"unsafe"
+
+	"github.com/google/btree"
+)
+
+// IndexExtractor 从默认 keyspace 的 key 和 value 中提取二级索引的 key，一条数据可以对应多个索引 key，返回空表示不建立索引
+// 在持有数据库写锁时调用，不能调用 DB 的方法
+type IndexExtractor func(key []byte, value []byte) [][]byte
+
+// secondaryEntry 二级索引中的一项，按照索引 key 和主 key 排序
+type secondaryEntry struct {
+	indexKey []byte
+	key      []byte
+}
+
+func compareSecondaryEntry(a, b secondaryEntry) int {
+	if cmp := bytes.Compare(a.indexKey, b.indexKey); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(a.key, b.key)
+}
+
+var (
+	// secondaryEntryMemory 每个索引项占用的内存，不包括索引 key 本身，btree 节点按照 2/3 的填充率估算
+	secondaryEntryMemory = int64(unsafe.Sizeof(secondaryEntry{}))*3/2 + int64(unsafe.Sizeof([]byte(nil)))
+	// secondaryKeyMemory 每个建立了索引的主 key 占用的内存，不包括主 key 本身
+	secondaryKeyMemory = int64(unsafe.Sizeof("") + unsafe.Sizeof([][]byte(nil)))
+)
+
+// secondaryIndex 二级索引，只保存在内存中，打开数据库时从数据重建，和数据文件不会不一致
+type secondaryIndex struct {
+	extractor IndexExtractor
+	tree      *btree.BTreeG[secondaryEntry]
+	indexKeys map[string][][]byte // 主 key 当前对应的索引 key，覆盖和删除时用于清除旧的索引
+	memory    int64               // 占用内存的估计值
+}
+
+func newSecondaryIndex(extractor IndexExtractor) *secondaryIndex {
+	return &secondaryIndex{
+		extractor: extractor,
+		tree: btree.NewG(32, func(a, b secondaryEntry) bool {
+			return compareSecondaryEntry(a, b) < 0
+		}),
+		indexKeys: make(map[string][][]byte),
+	}
+}
+
+// put 清除 key 原来的索引，根据新的 value 重新建立索引
+func (si *secondaryIndex) put(key []byte, value []byte) {
+	si.remove(key)
+	indexKeys := si.extractor(key, value)
+	if len(indexKeys) == 0 {
+		return
+	}
+	// 调用方的 key 和提取的索引 key 之后可能被修改，需要复制
+	key = append([]byte(nil), key...)
+	copied := make([][]byte, 0, len(indexKeys))
+	for _, indexKey := range indexKeys {
+		indexKey = append([]byte(nil), indexKey...)
+		copied = append(copied, indexKey)
+		si.tree.ReplaceOrInsert(secondaryEntry{indexKey: indexKey, key: key})
+	}
+	si.indexKeys[string(key)] = copied
+	si.memory += secondaryEntriesMemory(key, copied)
+}
+
+// remove 清除 key 的所有索引
+func (si *secondaryIndex) remove(key []byte) {
+	indexKeys, ok := si.indexKeys[string(key)]
+	if !ok {
+		return
+	}
+	for _, indexKey := range indexKeys {
+		si.tree.Delete(secondaryEntry{indexKey: indexKey, key: key})
+	}
+	delete(si.indexKeys, string(key))
+	si.memory -= secondaryEntriesMemory(key, indexKeys)
+}
+
+// estimatePut 估计 put 之后增加的内存，覆盖时减去原来的索引占用的内存
+func (si *secondaryIndex) estimatePut(key []byte, value []byte) int64 {
+	return secondaryEntriesMemory(key, si.extractor(key, value)) - secondaryEntriesMemory(key, si.indexKeys[string(key)])
+}
+
+// secondaryEntriesMemory 主 key 对应 indexKeys 时占用的内存，主 key 在 indexKeys 的 map 和索引项中各有一份
+func secondaryEntriesMemory(key []byte, indexKeys [][]byte) int64 {
+	if len(indexKeys) == 0 {
+		return 0
+	}
+	memory := secondaryKeyMemory + 2*int64(len(key))
+	for _, indexKey := range indexKeys {
+		memory += secondaryEntryMemory + int64(len(indexKey))
+	}
+	return memory
+}
+
+// IndexLookup 查找二级索引 name 中索引 key 为 indexKey 的所有主 key，按照主 key 排序
+func (db *DB) IndexLookup(name string, indexKey []byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	si, ok := db.secondary[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	var keys [][]byte
+	si.tree.AscendGreaterOrEqual(secondaryEntry{indexKey: indexKey}, func(entry secondaryEntry) bool {
+		if !bytes.Equal(entry.indexKey, indexKey) {
+			return false
+		}
+		keys = append(keys, entry.key)
+		return true
+	})
+	return keys, nil
+}
+
+// updateSecondaryIndexes 写入默认 keyspace 之后更新所有二级索引，调用时必须持有 db.mu 写锁
+func (db *DB) updateSecondaryIndexes(key []byte, value []byte) {
+	for _, si := range db.secondary {
+		si.put(key, value)
+	}
+}
+
+// secondaryIndexMemory 所有二级索引占用的内存，调用时必须持有 db.mu
+func (db *DB) secondaryIndexMemory() int64 {
+	var memory int64
+	for _, si := range db.secondary {
+		memory += si.memory
+	}
+	return memory
+}
+
+// removeSecondaryIndexes 从默认 keyspace 删除之后清除所有二级索引，调用时必须持有 db.mu 写锁
+func (db *DB) removeSecondaryIndexes(key []byte) {
+	for _, si := range db.secondary {
+		si.remove(key)
+	}
+}
+
+// loadSecondaryIndexes 索引加载完成之后读取默认 keyspace 的所有数据，重建二级索引
+func (db *DB) loadSecondaryIndexes(ctx context.Context) error {
+	if len(db.options.SecondaryIndexes) == 0 {
+		return nil
+	}
+	db.secondary = make(map[string]*secondaryIndex, len(db.options.SecondaryIndexes))
+	for name, extractor := range db.options.SecondaryIndexes {
+		db.secondary[name] = newSecondaryIndex(extractor)
+	}
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for ; iterator.Valid(); iterator.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		value, err := db.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		db.updateSecondaryIndexes(iterator.Key(), value)
+	}
+	return nil
+}
+
+// IndexIterator 二级索引迭代器，按照索引 key 和主 key 排序，遍历创建时的快照
+type IndexIterator struct {
+	db      *DB
+	tree    *btree.BTreeG[secondaryEntry]
+	options IteratorOptions // Prefix 作用于索引 key
+	curr    secondaryEntry
+	valid   bool
+}
+
+// NewIndexIterator 创建二级索引 name 的迭代器
+func (db *DB) NewIndexIterator(name string, opts IteratorOptions) (*IndexIterator, error) {
+	// Clone 会修改原来的树的写时复制标记，需要持有写锁
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	si, ok := db.secondary[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	it := &IndexIterator{
+		db:      db,
+		tree:    si.tree.Clone(),
+		options: opts,
+	}
+	it.Rewind()
+	return it, nil
+}
+
+// Rewind 重新回到迭代器起点，即第一个数据
+func (it *IndexIterator) Rewind() {
+	if it.options.Reverse {
+		it.curr, it.valid = it.tree.Max()
+	} else {
+		it.curr, it.valid = it.tree.Min()
+	}
+	it.skip2Next()
+}
+
+// Seek 查找第一个索引 key 大于(或小于)等于 indexKey 的数据，根据这个位置开始遍历
+func (it *IndexIterator) Seek(indexKey []byte) {
+	if it.options.Reverse {
+		// 索引 key 为 indexKey 的所有数据都小于 indexKey + 0x00
+		it.seek(secondaryEntry{indexKey: append(append([]byte(nil), indexKey...), 0)}, true)
+	} else {
+		it.seek(secondaryEntry{indexKey: indexKey}, true)
+	}
+	it.skip2Next()
+}
+
+// Next 跳转到下一个数据
+func (it *IndexIterator) Next() {
+	if !it.valid {
+		return
+	}
+	it.seek(it.curr, false)
+	it.skip2Next()
+}
+
+// Valid 是否已经遍历完所有数据，用于退出
+func (it *IndexIterator) Valid() bool {
+	return it.valid
+}
+
+// IndexKey 当前位置的索引 key
+func (it *IndexIterator) IndexKey() []byte {
+	return it.curr.indexKey
+}
+
+// Key 当前位置的主 key
+func (it *IndexIterator) Key() []byte {
+	return it.curr.key
+}
+
+// Value 读取主 key 当前的 value，创建迭代器之后被删除时返回 ErrKeyNotFound
+func (it *IndexIterator) Value() ([]byte, error) {
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	return it.db.get(nil, it.curr.key)
+}
+
+// Close 关闭迭代器，释放资源
+func (it *IndexIterator) Close() {
+	it.tree = nil
+	it.valid = false
+}
+
+// seek 移动到 pivot 之后（反向遍历时之前）的第一个数据，inclusive 为 false 时跳过 pivot 本身
+func (it *IndexIterator) seek(pivot secondaryEntry, inclusive bool) {
+	it.valid = false
+	visit := func(entry secondaryEntry) bool {
+		if !inclusive && compareSecondaryEntry(entry, pivot) == 0 {
+			return true
+		}
+		it.curr, it.valid = entry, true
+		return false
+	}
+	if it.options.Reverse {
+		it.tree.DescendLessOrEqual(pivot, visit)
+	} else {
+		it.tree.AscendGreaterOrEqual(pivot, visit)
+	}
+}
+
+func (it *IndexIterator) skip2Next() {
+	if len(it.options.Prefix) == 0 {
+		return
+	}
+	for it.valid && !bytes.HasPrefix(it.curr.indexKey, it.options.Prefix) {
+		it.seek(it.curr, false)
+	}
+}
@@ -0,0 +1,160 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// emailExtractor value 的格式为 name|email，按照 email 建立索引，没有 email 时不建立索引
+func emailExtractor(key []byte, value []byte) [][]byte {
+	i := bytes.IndexByte(value, '|')
+	if i < 0 || i == len(value)-1 {
+		return nil
+	}
+	return [][]byte{value[i+1:]}
+}
+
+func newSecondaryIndexOptions(pattern string) Options {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", pattern)
+	opt.DirPath = dir
+	opt.SecondaryIndexes = map[string]IndexExtractor{"email": emailExtractor}
+	return opt
+}
+
+func TestDB_IndexLookup(t *testing.T) {
+	opt := newSecondaryIndexOptions("bitcask-go-secondary-1")
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.写入时建立索引，多个 key 可以有相同的索引 key
+	assert.Nil(t, db.Put([]byte("user-1"), []byte("alice|alice@example.com")))
+	assert.Nil(t, db.Put([]byte("user-2"), []byte("bob|bob@example.com")))
+	assert.Nil(t, db.Put([]byte("user-3"), []byte("alice|alice@example.com")))
+	assert.Nil(t, db.Put([]byte("user-4"), []byte("nobody")))
+	keys, err := db.IndexLookup("email", []byte("alice@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-1"), []byte("user-3")}, keys)
+	keys, err = db.IndexLookup("email", []byte("alice"))
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+	_, err = db.IndexLookup("name", []byte("alice"))
+	assert.Equal(t, ErrIndexNotFound, err)
+
+	// 2.覆盖和删除时清除旧的索引
+	assert.Nil(t, db.Put([]byte("user-3"), []byte("carol|carol@example.com")))
+	assert.Nil(t, db.Delete([]byte("user-2")))
+	keys, _ = db.IndexLookup("email", []byte("alice@example.com"))
+	assert.Equal(t, [][]byte{[]byte("user-1")}, keys)
+	keys, _ = db.IndexLookup("email", []byte("carol@example.com"))
+	assert.Equal(t, [][]byte{[]byte("user-3")}, keys)
+	keys, _ = db.IndexLookup("email", []byte("bob@example.com"))
+	assert.Empty(t, keys)
+
+	// 3.批量写入提交之后才更新索引
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user-5"), []byte("dave|dave@example.com")))
+	assert.Nil(t, wb.Delete([]byte("user-1")))
+	keys, _ = db.IndexLookup("email", []byte("dave@example.com"))
+	assert.Empty(t, keys)
+	assert.Nil(t, wb.Commit())
+	keys, _ = db.IndexLookup("email", []byte("dave@example.com"))
+	assert.Equal(t, [][]byte{[]byte("user-5")}, keys)
+	keys, _ = db.IndexLookup("email", []byte("alice@example.com"))
+	assert.Empty(t, keys)
+
+	// 4.范围删除
+	assert.Nil(t, db.DeletePrefix([]byte("user-5")))
+	keys, _ = db.IndexLookup("email", []byte("dave@example.com"))
+	assert.Empty(t, keys)
+
+	// 5.命名 keyspace 不建立索引
+	bucket, err := db.Bucket("users")
+	assert.Nil(t, err)
+	assert.Nil(t, bucket.Put([]byte("user-6"), []byte("erin|erin@example.com")))
+	keys, _ = db.IndexLookup("email", []byte("erin@example.com"))
+	assert.Empty(t, keys)
+
+	// 6.重启之后从数据重建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	keys, _ = db.IndexLookup("email", []byte("carol@example.com"))
+	assert.Equal(t, [][]byte{[]byte("user-3")}, keys)
+	keys, _ = db.IndexLookup("email", []byte("alice@example.com"))
+	assert.Empty(t, keys)
+	keys, _ = db.IndexLookup("email", []byte("dave@example.com"))
+	assert.Empty(t, keys)
+
+	// 7.merge 之后重启，从 hint 文件加载的数据也可以重建索引
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	keys, _ = db.IndexLookup("email", []byte("carol@example.com"))
+	assert.Equal(t, [][]byte{[]byte("user-3")}, keys)
+}
+
+func TestDB_NewIndexIterator(t *testing.T) {
+	opt := newSecondaryIndexOptions("bitcask-go-secondary-2")
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users := map[string]string{
+		"user-1": "a|a@x.com",
+		"user-2": "b|b@y.com",
+		"user-3": "c|a@x.com",
+		"user-4": "d|c@x.com",
+	}
+	for key, value := range users {
+		assert.Nil(t, db.Put([]byte(key), []byte(value)))
+	}
+	_, err = db.NewIndexIterator("name", IteratorOptions{})
+	assert.Equal(t, ErrIndexNotFound, err)
+
+	// 1.按照索引 key 和主 key 排序
+	iter, err := db.NewIndexIterator("email", IteratorOptions{})
+	assert.Nil(t, err)
+	var got []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		got = append(got, string(iter.IndexKey())+" "+string(iter.Key()))
+	}
+	assert.Equal(t, []string{"a@x.com user-1", "a@x.com user-3", "b@y.com user-2", "c@x.com user-4"}, got)
+
+	// 2.Seek 和读取 value
+	iter.Seek([]byte("b"))
+	assert.Equal(t, "user-2", string(iter.Key()))
+	val, err := iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b|b@y.com"), val)
+	iter.Close()
+
+	// 3.反向遍历，Seek 定位到索引 key 相同的最后一个数据
+	iter, err = db.NewIndexIterator("email", IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	got = got[:0]
+	for iter.Seek([]byte("a@x.com")); iter.Valid(); iter.Next() {
+		got = append(got, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"user-3", "user-1"}, got)
+	iter.Close()
+
+	// 4.索引 key 的前缀，创建迭代器之后的修改不影响遍历
+	iter, err = db.NewIndexIterator("email", IteratorOptions{Prefix: []byte("c@")})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete([]byte("user-4")))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, "user-4", string(iter.Key()))
+	_, err = iter.Value()
+	assert.Equal(t, ErrKeyNotFound, err)
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
@@ -0,0 +1,167 @@
+package bitcask_go
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+const (
+	streamChunkSize       = 64 * 1024      // 流式写入时每次读取和写入的数据大小
+	streamTempFilePattern = "stream-*.tmp" // 流式写入的临时文件，重启时删除残留的文件
+)
+
+// PutReader 从 r 中读取 size 字节作为 value 写入，不需要把整个 value 放到内存中
+// 不持有锁时只读取一次 r，写入数据目录中的临时文件并计算 crc，之后在持有锁时从临时文件追加到数据文件
+// 注册了二级索引时，提取索引 key 需要完整的 value，写入之后会把整个 value 读取到内存中，大 value 不适合建立二级索引
+func (db *DB) PutReader(key []byte, r io.Reader, size int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if size < 0 || size > math.MaxInt32 {
+		return ErrInvalidValueSize
+	}
+	if err := db.checkKeySize(nil, key); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(db.options.DirPath, streamTempFilePattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+	valueCrc := crc32.NewIEEE()
+	if _, err := io.CopyN(io.MultiWriter(tmpFile, valueCrc), r, size); err != nil {
+		return unexpectedEOF(err)
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkIndexMemory(&data.LogRecord{Key: key}); err != nil {
+		return err
+	}
+
+	// 版本号需要在持有锁时生成，header 的 crc 和 value 的 crc 合并得到整条记录的 crc
+	logRecord := &data.LogRecord{
+		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type: data.LogRecordNormal,
+	}
+	logRecord.Version, logRecord.Timestamp = db.nextVersion()
+	header := data.EncodeLogRecordHeader(logRecord, size)
+	crc := data.CombineCRC(crc32.ChecksumIEEE(header[crc32.Size:]), valueCrc.Sum32(), size)
+	binary.LittleEndian.PutUint32(header[:crc32.Size], crc)
+
+	pos, err := db.appendStream(header, tmpFile, size)
+	if err != nil {
+		return err
+	}
+
+	// 更新内存索引
+	db.txnTracker.markWritten(nil, key)
+	if ok := db.indexPut(nil, key, pos); !ok {
+		return ErrIndexUpdateFailed
+	}
+	// 二级索引需要完整的 value，从数据文件中读取，这里会把整个 value 放到内存中
+	if len(db.secondary) > 0 {
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		db.updateSecondaryIndexes(key, value)
+	}
+	db.addHistory(nil, key, logRecord.Version, logRecord.Timestamp, pos, false)
+
+	// 流式写入的 value 不放到变更事件中
+	db.watchHub.notify([]*data.LogRecord{{Key: key, Version: logRecord.Version}})
+	return nil
+}
+
+// appendStream 将 header 和 r 中 size 字节的 value 分块追加到活跃文件，调用时必须持有 db.mu 写锁
+// 写入失败时截断已经写入的部分，之后的写入不会跟在不完整的记录后面
+func (db *DB) appendStream(header []byte, r io.Reader, size int64) (*data.LogRecordPos, error) {
+	if err := db.prepareActiveFile(int64(len(header)) + size); err != nil {
+		return nil, err
+	}
+	writeOff := db.activeFile.WriteOff
+	write := func() error {
+		if err := db.activeFile.Write(header); err != nil {
+			return err
+		}
+		buf := make([]byte, streamChunkSize)
+		for remain := size; remain > 0; {
+			n := int64(len(buf))
+			if remain < n {
+				n = remain
+			}
+			if _, err := io.ReadFull(r, buf[:n]); err != nil {
+				return unexpectedEOF(err)
+			}
+			if err := db.activeFile.Write(buf[:n]); err != nil {
+				return err
+			}
+			remain -= n
+		}
+		if db.options.SyncWrites {
+			return db.activeFile.Sync()
+		}
+		return nil
+	}
+	if err := write(); err != nil {
+		if truncErr := db.activeFile.Truncate(writeOff); truncErr != nil {
+			return nil, truncErr
+		}
+		return nil, err
+	}
+
+	return &data.LogRecordPos{
+		Fid:    db.activeFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(int64(len(header)) + size),
+	}, nil
+}
+
+// GetReader 根据 key 返回 value 的流式读取器，直接从数据文件中读取
+// 读取到末尾时校验 crc，校验失败返回 data.ErrInvalidCRC
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	dataFile := db.getDataFile(logRecordPos.Fid)
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+
+	valueReader, err := dataFile.NewLogRecordValueReader(logRecordPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if valueReader.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	return io.NopCloser(valueReader), nil
+}
+
+// unexpectedEOF 读取到的数据少于指定的大小时返回 io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
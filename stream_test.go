@@ -0,0 +1,196 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/data"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_PutReader(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-1")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := bytes.Repeat(utils.RandomValue(1000), 300)
+
+	// 1.写入完整的 value
+	err = db.PutReader(utils.GetTestKey(1), bytes.NewReader(value), int64(len(value)))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// 2.只读取 size 字节
+	err = db.PutReader(utils.GetTestKey(2), io.MultiReader(bytes.NewReader(value), bytes.NewReader(value)), int64(len(value)))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// 3.数据不够
+	err = db.PutReader(utils.GetTestKey(3), io.LimitReader(bytes.NewReader(value), 10), 100)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 4.value 为空
+	err = db.PutReader(utils.GetTestKey(4), bytes.NewReader(nil), 0)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(val))
+
+	// 5.重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
+
+func TestDB_PutReader_Failure(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-4")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	writeOff := db.activeFile.WriteOff
+
+	// 1.读取失败时不会写入数据文件，临时文件被删除
+	err = db.PutReader(utils.GetTestKey(2), io.LimitReader(bytes.NewReader(utils.RandomValue(100)), 10), 100)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+	tmpFiles, _ := filepath.Glob(filepath.Join(dir, streamTempFilePattern))
+	assert.Empty(t, tmpFiles)
+
+	// 2.追加到一半失败时截断已经写入的部分
+	header := data.EncodeLogRecordHeader(&data.LogRecord{Key: logRecordKeyWithSeq(utils.GetTestKey(3), nonTransactionSeqNo)}, 1000)
+	_, err = db.appendStream(header, bytes.NewReader(utils.RandomValue(100)), 1000)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+
+	// 3.之后的写入在重启之后仍然有效
+	err = db.Put(utils.GetTestKey(4), []byte("after"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 4.重启时删除残留的临时文件
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "stream-123.tmp"), []byte("partial"), 0644))
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), val)
+	_, err = os.Stat(filepath.Join(dir, "stream-123.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_GetReader(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-2")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	_, err = db.GetReader(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2.正常读取
+	value := bytes.Repeat(utils.RandomValue(1000), 100)
+	err = db.Put(utils.GetTestKey(1), value)
+	assert.Nil(t, err)
+	reader, err := db.GetReader(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	val, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.Nil(t, reader.Close())
+
+	// 3.数据被损坏，读取到末尾时校验失败
+	pos := db.index.Get(utils.GetTestKey(1))
+	file, err := os.OpenFile(data.GetDataFileName(dir, pos.Fid), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), pos.Offset+int64(len(value)/2))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	reader, err = db.GetReader(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(reader)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
+
+func TestDB_PutReader_Version(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-3")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	_, meta1, err := db.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	value := utils.RandomValue(100)
+	err = db.PutReader(utils.GetTestKey(2), bytes.NewReader(value), int64(len(value)))
+	assert.Nil(t, err)
+	val, meta2, err := db.GetWithMeta(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.True(t, meta2.Version > meta1.Version)
+}
+
+func TestDB_PutReader_SecondaryIndex(t *testing.T) {
+	opt := newSecondaryIndexOptions("bitcask-go-stream-5")
+	db, err := Open(opt)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	// 1.流式写入的 value 同样建立二级索引，覆盖时清除旧的索引
+	value := []byte("alice|alice@example.com")
+	assert.Nil(t, db.PutReader([]byte("user-1"), bytes.NewReader(value), int64(len(value))))
+	keys, err := db.IndexLookup("email", []byte("alice@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-1")}, keys)
+
+	value = []byte("alice|alice@example.org")
+	assert.Nil(t, db.PutReader([]byte("user-1"), bytes.NewReader(value), int64(len(value))))
+	keys, err = db.IndexLookup("email", []byte("alice@example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(keys))
+
+	// 2.重启之后重建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opt)
+	assert.Nil(t, err)
+	keys, err = db.IndexLookup("email", []byte("alice@example.org"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-1")}, keys)
+}
@@ -0,0 +1,200 @@
+package bitcask_go
+
+import (
+	"sync"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+// Txn 乐观事务，提交时检测读取过的 key 是否在事务开始后被修改
+type Txn struct {
+	options       TxnOptions
+	mu            *sync.Mutex
+	db            *DB
+	startTs       uint64                     // 事务开始时的写入时间戳
+	readSet       map[string]struct{}        // 事务中读取过的 key，使用 bucketKey 编码
+	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	closed        bool                       // 是否已经提交或丢弃
+}
+
+// txnTracker 记录活跃事务开始之后每个 key 最近一次写入的时间戳，用于提交时的冲突检测
+// 所有方法调用时必须持有 db.mu
+type txnTracker struct {
+	ts         uint64            // 写入时间戳，每次写入递增
+	active     map[uint64]int    // 活跃事务的开始时间戳 -> 事务数量
+	lastWrites map[string]uint64 // key 最近一次写入的时间戳，使用 bucketKey 编码，不同 keyspace 中相同的 key 互不影响
+}
+
+// NewTxn 开启一个新的事务
+func (db *DB) NewTxn(opts TxnOptions) *Txn {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return &Txn{
+		options:       opts,
+		mu:            new(sync.Mutex),
+		db:            db,
+		startTs:       db.txnTracker.begin(),
+		readSet:       make(map[string]struct{}),
+		pendingWrites: make(map[string]*data.LogRecord),
+	}
+}
+
+// Get 读取数据，优先读取事务中暂存的数据
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	// 事务中写过的数据直接返回
+	if record := txn.pendingWrites[string(key)]; record != nil {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	// 记录读取过的 key，包括不存在的 key
+	txn.readSet[bucketKey(nil, key)] = struct{}{}
+	return txn.db.Get(key)
+}
+
+// Put 在事务中写数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+	}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// Commit 提交事务，读取过的 key 在事务开始后被修改则返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	if uint(len(txn.pendingWrites)) > txn.options.MaxWriteNum {
+		return ErrExceedMaxBatchNum
+	}
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+
+	txn.closed = true
+	defer txn.db.txnTracker.finish(txn.startTs)
+
+	// 冲突检测
+	for key := range txn.readSet {
+		if txn.db.txnTracker.lastWrites[key] > txn.startTs {
+			return ErrTxnConflict
+		}
+	}
+
+	// 只读事务不需要写数据
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+	return txn.db.commitRecords(txn.pendingWrites, txn.options.SyncWrites)
+}
+
+// Discard 丢弃事务中暂存的数据，已经关闭的事务调用无影响
+func (txn *Txn) Discard() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return
+	}
+	txn.closed = true
+
+	txn.db.mu.Lock()
+	txn.db.txnTracker.finish(txn.startTs)
+	txn.db.mu.Unlock()
+}
+
+func newTxnTracker() *txnTracker {
+	return &txnTracker{
+		active:     make(map[uint64]int),
+		lastWrites: make(map[string]uint64),
+	}
+}
+
+// begin 登记一个活跃事务，返回事务开始的时间戳
+func (tt *txnTracker) begin() uint64 {
+	tt.active[tt.ts]++
+	return tt.ts
+}
+
+// finish 注销一个活跃事务，并清理不再需要的写入记录
+func (tt *txnTracker) finish(startTs uint64) {
+	if tt.active[startTs]--; tt.active[startTs] <= 0 {
+		delete(tt.active, startTs)
+	}
+
+	// 没有活跃事务，清空所有写入记录
+	if len(tt.active) == 0 {
+		tt.lastWrites = make(map[string]uint64)
+		return
+	}
+
+	// 早于所有活跃事务的写入不会再引起冲突
+	minTs := tt.ts
+	for ts := range tt.active {
+		if ts < minTs {
+			minTs = ts
+		}
+	}
+	for key, ts := range tt.lastWrites {
+		if ts <= minTs {
+			delete(tt.lastWrites, key)
+		}
+	}
+}
+
+// markWritten 记录 keyspace 中 key 的一次写入
+func (tt *txnTracker) markWritten(bucket []byte, key []byte) {
+	tt.ts++
+	// 没有活跃事务，不需要记录
+	if len(tt.active) == 0 {
+		return
+	}
+	tt.lastWrites[bucketKey(bucket, key)] = tt.ts
+}
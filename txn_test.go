@@ -0,0 +1,140 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	// 1.事务中可以读到自己写入的数据
+	txn := db.NewTxn(DefaultTxnOptions)
+	val, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), val)
+	err = txn.Put(utils.GetTestKey(1), []byte("50"))
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(2), []byte("50"))
+	assert.Nil(t, err)
+	val, err = txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("50"), val)
+
+	// 2.提交之前其他人读不到
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("50"), val)
+
+	// 3.已经提交的事务不能再使用
+	err = txn.Put(utils.GetTestKey(3), []byte("1"))
+	assert.Equal(t, ErrTxnClosed, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnClosed, err)
+
+	// 4.丢弃的事务不会写入数据
+	txn2 := db.NewTxn(DefaultTxnOptions)
+	err = txn2.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	txn2.Discard()
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 5.重启之后数据仍然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("50"), val)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	// 1.读取的 key 被普通写入修改
+	txn1 := db.NewTxn(DefaultTxnOptions)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), []byte("80"))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("70"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("80"), val)
+
+	// 2.读取的 key 被其他事务修改
+	txn2 := db.NewTxn(DefaultTxnOptions)
+	txn3 := db.NewTxn(DefaultTxnOptions)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(1), []byte("60"))
+	assert.Nil(t, err)
+	err = txn3.Put(utils.GetTestKey(1), []byte("40"))
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 3.读取不存在的 key 之后被写入
+	txn4 := db.NewTxn(DefaultTxnOptions)
+	_, err = txn4.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(2), []byte("1"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 4.事务开始之前的写入和其他 keyspace 中相同 key 的写入不会引起冲突
+	txn5 := db.NewTxn(DefaultTxnOptions)
+	_, err = txn5.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn5.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), []byte("1"))
+	assert.Nil(t, err)
+	users, _ := db.Bucket("users")
+	err = users.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+	err = txn5.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.txnTracker.lastWrites))
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限速器，限制每秒处理的字节数，可以被多个 goroutine 共享
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // 每秒产生的令牌数
+	burst  float64   // 令牌桶容量
+	tokens float64   // 当前令牌数，可以为负数，表示已经预支的令牌
+	last   time.Time // 上一次更新令牌数的时间
+}
+
+// NewRateLimiter 创建每秒最多处理 bytesPerSec 字节的限速器，bytesPerSec 小于等于 0 时返回 nil，表示不限速
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// WaitN 获取 n 字节的令牌，令牌不足时阻塞到令牌足够或者 ctx 结束
+// n 可以大于每秒的限额，不足的部分从后续的令牌中预支
+func (rl *RateLimiter) WaitN(ctx context.Context, n int64) error {
+	if rl == nil || n <= 0 {
+		return ctx.Err()
+	}
+
+	rl.mu.Lock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	var wait time.Duration
+	if rl.tokens < 0 {
+		wait = time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	if wait == 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_WaitN(t *testing.T) {
+	// 1.不限速
+	var rl *RateLimiter
+	assert.Nil(t, rl.WaitN(context.Background(), 1024))
+	assert.Nil(t, NewRateLimiter(0))
+
+	// 2.超过限额之后需要等待
+	rl = NewRateLimiter(1000)
+	start := time.Now()
+	assert.Nil(t, rl.WaitN(context.Background(), 1000))
+	assert.Nil(t, rl.WaitN(context.Background(), 200))
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// 3.ctx 结束时停止等待
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := rl.WaitN(ctx, 10000)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,169 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/xavier-tse/bitcask-go/data"
+)
+
+type WatchEventType = byte
+
+const (
+	WatchEventPut WatchEventType = iota + 1
+	WatchEventDelete
+)
+
+// WatchEvent 一个 key 的变更事件
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   []byte
+	Value []byte // 删除事件和 PutReader 流式写入的事件为空
+	SeqNo uint64 // 变更的写入版本号，同一次原子写入中的事件版本号相同
+}
+
+// WatchResponse 一次原子写入产生的事件，WriteBatch 提交的所有事件在同一个 WatchResponse 中
+type WatchResponse struct {
+	Events []*WatchEvent
+
+	// 缓冲区已满，之前有事件被丢弃，消费方需要重新同步数据
+	Overflow bool
+}
+
+// watcher 一个订阅者
+type watcher struct {
+	bucket     []byte // 订阅的 keyspace，为空表示默认 keyspace
+	prefix     []byte
+	ch         chan *WatchResponse // 比缓冲区大小多一个位置，留给 Overflow 通知
+	size       int
+	overflowed bool // 最后发送到 channel 的是 Overflow 通知
+}
+
+// watchHub 管理所有订阅者，并分发变更事件
+type watchHub struct {
+	mu       *sync.Mutex
+	watchers map[*watcher]struct{}
+	closed   chan struct{} // 数据库关闭时被关闭，通知等待 ctx 的 goroutine 退出
+}
+
+// Watch 订阅以 prefix 为前缀的 key 的变更，ctx 结束或者数据库关闭时 channel 会被关闭
+// 每个订阅者的缓冲区大小由 Options.WatchBufferSize 指定，缓冲区已满时事件会被丢弃，
+// 并立即发送一个 Overflow 为 true 的 WatchResponse
+func (db *DB) Watch(ctx context.Context, prefix []byte) <-chan *WatchResponse {
+	return db.watch(ctx, nil, prefix)
+}
+
+func (db *DB) watch(ctx context.Context, bucket []byte, prefix []byte) <-chan *WatchResponse {
+	w := &watcher{
+		bucket: bucket,
+		prefix: prefix,
+		ch:     make(chan *WatchResponse, db.options.WatchBufferSize+1),
+		size:   db.options.WatchBufferSize,
+	}
+	if !db.watchHub.add(w) {
+		return w.ch
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.watchHub.remove(w)
+		case <-db.watchHub.closed:
+		}
+	}()
+	return w.ch
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		mu:       new(sync.Mutex),
+		watchers: make(map[*watcher]struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// add 添加订阅者，数据库已经关闭时直接关闭订阅者的 channel 并返回 false
+func (wh *watchHub) add(w *watcher) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	select {
+	case <-wh.closed:
+		close(w.ch)
+		return false
+	default:
+	}
+	wh.watchers[w] = struct{}{}
+	return true
+}
+
+func (wh *watchHub) remove(w *watcher) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if _, ok := wh.watchers[w]; ok {
+		delete(wh.watchers, w)
+		close(w.ch)
+	}
+}
+
+// closeAll 关闭所有订阅者
+func (wh *watchHub) closeAll() {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	select {
+	case <-wh.closed:
+		return
+	default:
+	}
+	for w := range wh.watchers {
+		close(w.ch)
+	}
+	wh.watchers = make(map[*watcher]struct{})
+	close(wh.closed)
+}
+
+// notify 分发一次原子写入产生的变更，不会阻塞写入，调用时必须持有 db.mu 以保证事件顺序
+func (wh *watchHub) notify(records []*data.LogRecord) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if len(wh.watchers) == 0 || len(records) == 0 {
+		return
+	}
+
+	events := make([]*WatchEvent, 0, len(records))
+	for _, record := range records {
+		event := &WatchEvent{
+			Type:  WatchEventPut,
+			Key:   append([]byte(nil), record.Key...),
+			SeqNo: record.Version,
+		}
+		if record.Type == data.LogRecordDeleted {
+			event.Type = WatchEventDelete
+		} else {
+			event.Value = append([]byte(nil), record.Value...)
+		}
+		events = append(events, event)
+	}
+
+	for w := range wh.watchers {
+		var matched []*WatchEvent
+		for i, event := range events {
+			if bytes.Equal(records[i].Bucket, w.bucket) && bytes.HasPrefix(event.Key, w.prefix) {
+				matched = append(matched, event)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		// 只有 notify 会发送数据，并且持有 wh.mu，所以 len 只会变小，
+		// 普通事件最多占用 size 个位置，丢弃时总有空位立即发送 Overflow 通知
+		if len(w.ch) < w.size {
+			w.ch <- &WatchResponse{Events: matched}
+			w.overflowed = false
+		} else if !w.overflowed {
+			w.ch <- &WatchResponse{Overflow: true}
+			w.overflowed = true
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package bitcask_go
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xavier-tse/bitcask-go/utils"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-1")
+	opt.DirPath = dir
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := db.Watch(ctx, []byte("user/"))
+
+	// 1.Put 和 Delete
+	err = db.Put([]byte("user/1"), []byte("a"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("order/1"), []byte("b"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user/1"))
+	assert.Nil(t, err)
+
+	resp := <-ch
+	assert.Equal(t, 1, len(resp.Events))
+	assert.Equal(t, WatchEventPut, resp.Events[0].Type)
+	assert.Equal(t, []byte("user/1"), resp.Events[0].Key)
+	assert.Equal(t, []byte("a"), resp.Events[0].Value)
+	firstSeqNo := resp.Events[0].SeqNo
+
+	resp = <-ch
+	assert.Equal(t, 1, len(resp.Events))
+	assert.Equal(t, WatchEventDelete, resp.Events[0].Type)
+	assert.Nil(t, resp.Events[0].Value)
+	assert.True(t, resp.Events[0].SeqNo > firstSeqNo)
+
+	// 2.WriteBatch 的事件在同一个 WatchResponse 中
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put([]byte("user/2"), []byte("c"))
+	assert.Nil(t, err)
+	err = wb.Put([]byte("user/3"), []byte("d"))
+	assert.Nil(t, err)
+	err = wb.Put([]byte("order/2"), []byte("e"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	resp = <-ch
+	assert.Equal(t, 2, len(resp.Events))
+	assert.Equal(t, resp.Events[0].SeqNo, resp.Events[1].SeqNo)
+
+	// 3.ctx 结束之后 channel 被关闭
+	cancel()
+	for range ch {
+	}
+	err = db.Put([]byte("user/4"), []byte("f"))
+	assert.Nil(t, err)
+}
+
+func TestDB_Watch_Overflow(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-2")
+	opt.DirPath = dir
+	opt.WatchBufferSize = 2
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ch := db.Watch(context.Background(), nil)
+
+	// 1.缓冲区满了之后事件被丢弃，立即通知订阅者
+	for i := 0; i < 5; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	resp := <-ch
+	assert.Equal(t, utils.GetTestKey(0), resp.Events[0].Key)
+	resp = <-ch
+	assert.Equal(t, utils.GetTestKey(1), resp.Events[0].Key)
+	select {
+	case resp = <-ch:
+		assert.True(t, resp.Overflow)
+	default:
+		t.Fatal("overflow should be delivered without waiting for the next event")
+	}
+
+	// 2.读取之后恢复正常
+	err = db.Put(utils.GetTestKey(5), utils.RandomValue(10))
+	assert.Nil(t, err)
+	resp = <-ch
+	assert.False(t, resp.Overflow)
+	assert.Equal(t, utils.GetTestKey(5), resp.Events[0].Key)
+
+	// 3.关闭数据库时 channel 被关闭，关闭之后订阅的 channel 直接被关闭
+	err = db.Close()
+	assert.Nil(t, err)
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, ok = <-db.Watch(context.Background(), nil)
+	assert.False(t, ok)
+}